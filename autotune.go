@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var autotune = flag.Bool("autotune", false, "pick -minalloc by running short calibration bursts at values from "+
+	"256KB to 64MB and keeping the one with the best throughput within -maxmem, then run the benchmark with it")
+
+var maxMem byteSize
+
+func init() {
+	flag.Var(&maxMem, "maxmem", "peak RSS `limit` such as 2GiB that -autotune must keep the calibration bursts under, "+
+		"and that the estimated memory of the run must fit, or it does not start (0 means no limit)")
+}
+
+const (
+	// autotuneMinMB and autotuneMaxMB bound the -minalloc values tried by
+	// -autotune, which doubles from one to the next.
+	autotuneMinMB = 0.25
+	autotuneMaxMB = 64
+
+	// autotuneScale scales down the number of trees of a calibration burst,
+	// so that all of them together take about half as long as the run.
+	autotuneScale = 1.0 / 16
+)
+
+// Autotune runs a calibration burst at each -minalloc value between
+// autotuneMinMB and autotuneMaxMB, and returns the one with the most nodes
+// per second among those that kept the peak RSS under -maxmem. If none did,
+// it returns the one with the lowest peak RSS. The bursts build a fraction
+// of the trees of the run, always by iteration count, and it prints their
+// comparison and the reason for the pick. It returns the first error from
+// bintree.Run.
+func Autotune(cfg bintree.Config) (float64, error) {
+	cal := cfg
+	cal.IterScale *= autotuneScale
+	cal.BenchTime = 0
+	cal.Warmup = 0
+	cal.WarmupTime = 0
+	cal.Quiet = true
+
+	var mbs []float64
+	var passes []passResult
+	for mb := autotuneMinMB; mb <= autotuneMaxMB; mb *= 2 {
+		cal.MinAllocMB = mb
+		cal.Label = fmt.Sprintf("%gMB", mb)
+		settleGC()
+		p, err := runPass(cal)
+		if err != nil {
+			return 0, err
+		}
+		mbs = append(mbs, mb)
+		passes = append(passes, p)
+	}
+
+	best, fits := -1, false
+	for i, p := range passes {
+		under := maxMem <= 0 || p.gc.PeakRSS <= uint64(maxMem)
+		switch {
+		case best < 0,
+			under && !fits,
+			under && p.nodesPerSec() > passes[best].nodesPerSec(),
+			!under && !fits && p.gc.PeakRSS < passes[best].gc.PeakRSS:
+			best, fits = i, under
+		}
+	}
+
+	// The run's own output goes to out, so only the text format has room
+	// for the calibration.
+	w := io.Writer(os.Stderr)
+	if cfg.Format == "text" {
+		w = out
+	}
+	fmt.Fprintf(w, "%-10s %12s %14s %8s %12s\n", "minalloc", "wall", "nodes/sec", "arenas", "peak RSS MB")
+	for i, p := range passes {
+		fmt.Fprintf(w, "%-10s %12v %14.0f %8d %12.1f\n",
+			fmt.Sprintf("%gMB", mbs[i]),
+			p.elapsed.Round(time.Millisecond),
+			p.nodesPerSec(),
+			bintree.SumResults(p.results).Arenas,
+			float64(p.gc.PeakRSS)/(1<<20))
+	}
+	b := passes[best]
+	switch {
+	case !fits:
+		fmt.Fprintf(w, "autotune: -minalloc=%g, no value kept the peak RSS under -maxmem=%.1fMB, this one had the lowest (%.1fMB)\n",
+			mbs[best], float64(maxMem)/(1<<20), float64(b.gc.PeakRSS)/(1<<20))
+	case maxMem > 0:
+		fmt.Fprintf(w, "autotune: -minalloc=%g, the most nodes/sec (%.0f) with a peak RSS of %.1fMB under -maxmem=%.1fMB\n",
+			mbs[best], b.nodesPerSec(), float64(b.gc.PeakRSS)/(1<<20), float64(maxMem)/(1<<20))
+	default:
+		fmt.Fprintf(w, "autotune: -minalloc=%g, the most nodes/sec (%.0f)\n", mbs[best], b.nodesPerSec())
+	}
+	fmt.Fprintln(w)
+	if maxMem > 0 && b.gc.PeakRSS == 0 {
+		slog.Warn("autotune: peak RSS is not available on this platform, -maxmem was not enforced")
+	}
+	return mbs[best], nil
+}
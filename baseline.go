@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var (
+	baselineFile = flag.String("baseline", "", "compare the run depth by depth against the results in `file`, "+
+		"saved with -format=json or -out (the first run in it)")
+	force = flag.Bool("force", false, "with -baseline, compare runs whose depth or iteration counts differ")
+)
+
+// baselineNoise is the relative change below which -baseline calls a
+// difference noise rather than better or worse.
+const baselineNoise = 2.0 // percent
+
+// loadBaseline reads the first results in path.
+func loadBaseline(path string) (*bintree.Results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res bintree.Results
+	if err := json.NewDecoder(f).Decode(&res); err != nil {
+		return nil, fmt.Errorf("could not read baseline %s: %w", path, err)
+	}
+	return &res, nil
+}
+
+// baselineMismatch returns an error describing why cur is not comparable to
+// old: a different tree depth, -benchtime, or number of trees at a depth
+// both ran.
+func baselineMismatch(old, cur *bintree.Results) error {
+	if old.Depth != cur.Depth {
+		return fmt.Errorf("the baseline has depth %d, this run %d", old.Depth, cur.Depth)
+	}
+	if old.BenchTime != cur.BenchTime {
+		return fmt.Errorf("the baseline has benchtime %v, this run %v", old.BenchTime, cur.BenchTime)
+	}
+	if cur.BenchTime > 0 {
+		// The number of trees is what was measured.
+		return nil
+	}
+	for _, c := range cur.Depths {
+		if o, ok := depthResult(old, c.Depth); ok && o.Iterations != c.Iterations {
+			return fmt.Errorf("the baseline built %d trees of depth %d, this run %d", o.Iterations, c.Depth, c.Iterations)
+		}
+	}
+	return nil
+}
+
+// depthResult returns the result of res for depth.
+func depthResult(res *bintree.Results, depth int) (bintree.Result, bool) {
+	for _, r := range res.Depths {
+		if r.Depth == depth {
+			return r, true
+		}
+	}
+	return bintree.Result{}, false
+}
+
+// CompareBaseline prints the per-depth time, arenas and nodes/sec of cur,
+// described by curName, against those of old, from the file oldName, matched
+// by depth, followed by the totals and peak RSS, each with its change and
+// whether it is better or worse. Depths that only one of them ran are logged
+// and skipped. Unless -force is set, it refuses to compare runs that
+// baselineMismatch rejects.
+func CompareBaseline(w io.Writer, oldName, curName string, old, cur *bintree.Results) error {
+	if err := baselineMismatch(old, cur); err != nil {
+		if !*force {
+			return fmt.Errorf("not comparing with the baseline: %v (use -force to compare anyway)", err)
+		}
+		slog.Warn("comparing with the baseline anyway", "err", err)
+	}
+	for _, o := range old.Depths {
+		if _, ok := depthResult(cur, o.Depth); !ok {
+			slog.Warn("depth is only in the baseline", "depth", o.Depth)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "baseline: %s (%s) vs %s (%s)\n", oldName, old.GoVersion, curName, cur.GoVersion)
+	fmt.Fprintf(w, "%-10s %10s %10s %8s %10s %10s %14s %14s %8s\n",
+		"", "old ms", "new ms", "delta", "old arenas", "new arenas", "old nodes/sec", "new nodes/sec", "delta")
+	row := func(name string, o, c bintree.Result) {
+		oldRate, newRate := o.NodesPerSec(), c.NodesPerSec()
+		fmt.Fprintf(w, "%-10s %10.1f %10.1f %7.1f%% %10d %10d %14.0f %14.0f %7.1f%%  %s\n",
+			name,
+			float64(o.Elapsed)/float64(time.Millisecond),
+			float64(c.Elapsed)/float64(time.Millisecond),
+			percentDelta(float64(o.Elapsed), float64(c.Elapsed)),
+			o.Arenas,
+			c.Arenas,
+			oldRate,
+			newRate,
+			percentDelta(oldRate, newRate),
+			verdict(percentDelta(oldRate, newRate)))
+	}
+	for _, c := range cur.Depths {
+		o, ok := depthResult(old, c.Depth)
+		if !ok {
+			slog.Warn("depth is not in the baseline", "depth", c.Depth)
+			continue
+		}
+		row(fmt.Sprintf("depth %d", c.Depth), o, c)
+	}
+	// The totals as a result, so the wall time is compared like a depth.
+	total := func(t bintree.Totals) bintree.Result {
+		return bintree.Result{Arenas: t.Arenas, Nodes: t.Nodes, Elapsed: t.Elapsed}
+	}
+	row("total", total(old.Totals), total(cur.Totals))
+
+	if old.GC.PeakRSS > 0 && cur.GC.PeakRSS > 0 {
+		// Less memory is better, so the verdict is on the negated change.
+		delta := percentDelta(float64(old.GC.PeakRSS), float64(cur.GC.PeakRSS))
+		fmt.Fprintf(w, "%-10s %10.1f %10.1f %7.1f%%  %s\n",
+			"peak RSS", float64(old.GC.PeakRSS)/(1<<20), float64(cur.GC.PeakRSS)/(1<<20), delta, verdict(-delta))
+	}
+	return nil
+}
+
+// verdict describes a change in percent where more is better.
+func verdict(delta float64) string {
+	switch {
+	case delta > baselineNoise:
+		return "better"
+	case delta < -baselineNoise:
+		return "WORSE"
+	default:
+		return "~"
+	}
+}
@@ -0,0 +1,475 @@
+package bintree
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Allocator allocates tree nodes.
+type Allocator[T any] interface {
+	// NewTreeNode returns a new zeroed tree node.
+	NewTreeNode() *Tree[T]
+
+	// Reset releases all nodes allocated so far and prepares the allocator
+	// for more allocations. Nodes allocated before Reset must not be used.
+	Reset()
+
+	// Free releases all nodes allocated so far. The allocator must not be
+	// used afterwards.
+	Free()
+
+	// Arenas returns how many arenas the allocator has created.
+	Arenas() int
+}
+
+// allocatorNames lists the allocation strategies.
+var allocatorNames = []string{"arena", "heap", "pool", "slab", "freelist", "prealloc"}
+
+// AllocatorNames returns the sorted names of the allocation strategies.
+func AllocatorNames() []string {
+	names := append([]string(nil), allocatorNames...)
+	sort.Strings(names)
+	return names
+}
+
+func validAllocator(name string) bool {
+	for _, n := range allocatorNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newAllocatorFunc returns a constructor for allocators of the cfg.Alloc
+// strategy. Allocators from the same constructor share state such as the
+// node pool.
+func newAllocatorFunc[T any](cfg *Config) func() Allocator[T] {
+	switch cfg.Alloc {
+	case "heap":
+		return func() Allocator[T] { return HeapAllocator[T]{} }
+	case "pool":
+		pool := new(sync.Pool)
+		return func() Allocator[T] { return NewPoolAllocator[T](pool) }
+	case "slab":
+		return func() Allocator[T] { return NewSlabAllocator[T](cfg.ChunkNodes) }
+	case "freelist":
+		return func() Allocator[T] { return NewFreeListAllocator[T](cfg.ChunkNodes) }
+	case "prealloc":
+		return func() Allocator[T] { return NewPreallocAllocator[T]() }
+	default:
+		return func() Allocator[T] { return NewArenaAllocator[T]() }
+	}
+}
+
+// ArenaAllocator allocates tree nodes from an arena, replacing the arena
+// with a new one on Reset.
+type ArenaAllocator[T any] struct {
+	arena  *countingArena
+	arenas int
+
+	// freeElapsed is the time spent in arena.Free.
+	freeElapsed time.Duration
+}
+
+// NewArenaAllocator returns an allocator with a fresh arena.
+func NewArenaAllocator[T any]() *ArenaAllocator[T] {
+	return &ArenaAllocator[T]{arena: newCountingArena(), arenas: 1}
+}
+
+func (a *ArenaAllocator[T]) NewTreeNode() *Tree[T] {
+	return arenaNew[Tree[T]](a.arena)
+}
+
+func (a *ArenaAllocator[T]) Reset() {
+	a.freeArena()
+	a.arena = newCountingArena()
+	a.arenas++
+}
+
+// Leak replaces the arena with a new one without freeing it.
+func (a *ArenaAllocator[T]) Leak() {
+	a.arena = newCountingArena()
+	a.arenas++
+}
+
+func (a *ArenaAllocator[T]) Free() {
+	if a.arena != nil {
+		a.freeArena()
+		a.arena = nil
+	}
+}
+
+// freeArena frees the arena, timing the call.
+func (a *ArenaAllocator[T]) freeArena() {
+	start := time.Now()
+	a.arena.Free()
+	a.freeElapsed += time.Since(start)
+}
+
+// FreeElapsed returns the time spent freeing arenas.
+func (a *ArenaAllocator[T]) FreeElapsed() time.Duration { return a.freeElapsed }
+
+func (a *ArenaAllocator[T]) Arenas() int { return a.arenas }
+
+func (a *ArenaAllocator[T]) MakeBytes(n int) []byte {
+	return arenaMakeSlice[byte](a.arena, n, n)
+}
+
+// AllocatedBytes returns the bytes allocated from the current arena, or 0
+// once the allocator is freed.
+func (a *ArenaAllocator[T]) AllocatedBytes() int {
+	if a.arena == nil {
+		return 0
+	}
+	return a.arena.AllocatedBytes()
+}
+
+// HeapAllocator allocates tree nodes from the regular GC heap.
+// Reset and Free are no-ops.
+type HeapAllocator[T any] struct{}
+
+func (HeapAllocator[T]) NewTreeNode() *Tree[T] { return &Tree[T]{} }
+func (HeapAllocator[T]) Reset()                {}
+func (HeapAllocator[T]) Free()                 {}
+func (HeapAllocator[T]) Arenas() int           { return 0 }
+
+// TreeReleaser is implemented by allocators that recycle the nodes of a
+// tree once it is no longer used.
+type TreeReleaser[T any] interface {
+	ReleaseTree(t *Tree[T])
+}
+
+// ByteAllocator is implemented by allocators that can also allocate byte
+// buffers, such as those backed by an arena.
+type ByteAllocator interface {
+	MakeBytes(n int) []byte
+}
+
+// allocBytes returns a zeroed buffer of n bytes from a, looking through
+// allocators that wrap another one, or from the regular heap if a does not
+// allocate byte buffers.
+func allocBytes[T any](a Allocator[T], n int) []byte {
+	for {
+		if ba, ok := a.(ByteAllocator); ok {
+			return ba.MakeBytes(n)
+		}
+		w, ok := a.(interface{ Unwrap() Allocator[T] })
+		if !ok {
+			return make([]byte, n)
+		}
+		a = w.Unwrap()
+	}
+}
+
+// ByteCounter is implemented by allocators that count the bytes they have
+// allocated since they were created or last Reset, including any rounding
+// up to whole chunks.
+type ByteCounter interface {
+	AllocatedBytes() int
+}
+
+// ArenaLeaker is implemented by allocators that can replace their arena
+// without freeing the old one, leaving it for the GC to reclaim.
+type ArenaLeaker interface {
+	Leak()
+}
+
+// arenaLeaker returns the ArenaLeaker of a, looking through allocators that
+// wrap another one.
+func arenaLeaker[T any](a Allocator[T]) (ArenaLeaker, bool) {
+	for {
+		if l, ok := a.(ArenaLeaker); ok {
+			return l, true
+		}
+		w, ok := a.(interface{ Unwrap() Allocator[T] })
+		if !ok {
+			return nil, false
+		}
+		a = w.Unwrap()
+	}
+}
+
+// byteCounter returns the ByteCounter of a, looking through allocators that
+// wrap another one.
+func byteCounter[T any](a Allocator[T]) (ByteCounter, bool) {
+	for {
+		if c, ok := a.(ByteCounter); ok {
+			return c, true
+		}
+		w, ok := a.(interface{ Unwrap() Allocator[T] })
+		if !ok {
+			return nil, false
+		}
+		a = w.Unwrap()
+	}
+}
+
+// FreeTimer is implemented by allocators that time the frees of their
+// arenas. The arena pool frees arenas itself, so its allocators do not.
+type FreeTimer interface {
+	FreeElapsed() time.Duration
+}
+
+// freeElapsed returns the time a has spent freeing arenas, looking through
+// allocators that wrap another one, or 0 if it does not time them.
+func freeElapsed[T any](a Allocator[T]) time.Duration {
+	for {
+		if t, ok := a.(FreeTimer); ok {
+			return t.FreeElapsed()
+		}
+		w, ok := a.(interface{ Unwrap() Allocator[T] })
+		if !ok {
+			return 0
+		}
+		a = w.Unwrap()
+	}
+}
+
+// allocatedBytes returns the bytes a has allocated since it was created or
+// last Reset, or fallback if a does not count them.
+func allocatedBytes[T any](a Allocator[T], fallback int) int {
+	if c, ok := byteCounter(a); ok {
+		return c.AllocatedBytes()
+	}
+	return fallback
+}
+
+// AllocStats holds counters reported by allocators that recycle nodes,
+// allocate them in chunks, or allocate some of them on the heap.
+type AllocStats struct {
+	Gets   int `json:"gets,omitempty"`   // nodes requested from a recycling allocator
+	Hits   int `json:"hits,omitempty"`   // requests served by a recycled node
+	Chunks int `json:"chunks,omitempty"` // chunks of nodes allocated
+	Heap   int `json:"heap,omitempty"`   // nodes allocated on the heap instead of the arena
+}
+
+// AllocStatser is implemented by allocators that report AllocStats.
+type AllocStatser interface {
+	AllocStats() AllocStats
+}
+
+// allocStats returns the AllocStats of a, or the zero value if a does not
+// report any.
+func allocStats[T any](a Allocator[T]) AllocStats {
+	if s, ok := a.(AllocStatser); ok {
+		return s.AllocStats()
+	}
+	return AllocStats{}
+}
+
+// sub returns the counters accumulated since prev.
+func (s AllocStats) sub(prev AllocStats) AllocStats {
+	return AllocStats{
+		Gets:   s.Gets - prev.Gets,
+		Hits:   s.Hits - prev.Hits,
+		Chunks: s.Chunks - prev.Chunks,
+		Heap:   s.Heap - prev.Heap,
+	}
+}
+
+// orNil returns nil if s is all zeros, and &s otherwise.
+func (s AllocStats) orNil() *AllocStats {
+	if s == (AllocStats{}) {
+		return nil
+	}
+	return &s
+}
+
+// HitRate returns the fraction of Gets served by a recycled node.
+func (s AllocStats) HitRate() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// PoolAllocator allocates tree nodes from a sync.Pool, returning them to the
+// pool via ReleaseTree. Reset and Free are no-ops.
+type PoolAllocator[T any] struct {
+	pool  *sync.Pool
+	stats AllocStats
+}
+
+// NewPoolAllocator returns an allocator that recycles nodes through pool,
+// which may be shared by several allocators. The pool must not have a New
+// func, so that misses can be counted.
+func NewPoolAllocator[T any](pool *sync.Pool) *PoolAllocator[T] {
+	return &PoolAllocator[T]{pool: pool}
+}
+
+func (a *PoolAllocator[T]) NewTreeNode() *Tree[T] {
+	a.stats.Gets++
+	if t, _ := a.pool.Get().(*Tree[T]); t != nil {
+		a.stats.Hits++
+		*t = Tree[T]{}
+		return t
+	}
+	return &Tree[T]{}
+}
+
+// ReleaseTree returns every node of t to the pool.
+func (a *PoolAllocator[T]) ReleaseTree(t *Tree[T]) {
+	if t == nil {
+		return
+	}
+	a.ReleaseTree(t.Left)
+	a.ReleaseTree(t.Right)
+	a.pool.Put(t)
+}
+
+func (a *PoolAllocator[T]) Reset()                 {}
+func (a *PoolAllocator[T]) Free()                  {}
+func (a *PoolAllocator[T]) Arenas() int            { return 0 }
+func (a *PoolAllocator[T]) AllocStats() AllocStats { return a.stats }
+
+// SlabAllocator allocates tree nodes by bumping an index into chunks of
+// nodes obtained from an arena with arena.MakeSlice, grabbing a new chunk
+// when the current one is exhausted.
+type SlabAllocator[T any] struct {
+	ArenaAllocator[T]
+	chunkNodes int
+	slab       []Tree[T]
+	chunks     int
+}
+
+// NewSlabAllocator returns a slab allocator with a fresh arena, allocating
+// chunks of chunkNodes nodes.
+func NewSlabAllocator[T any](chunkNodes int) *SlabAllocator[T] {
+	return &SlabAllocator[T]{ArenaAllocator: *NewArenaAllocator[T](), chunkNodes: chunkNodes}
+}
+
+func (a *SlabAllocator[T]) NewTreeNode() *Tree[T] {
+	if len(a.slab) == cap(a.slab) {
+		a.slab = arenaMakeSlice[Tree[T]](a.arena, 0, a.chunkNodes)
+		a.chunks++
+	}
+	a.slab = a.slab[:len(a.slab)+1]
+	return &a.slab[len(a.slab)-1]
+}
+
+func (a *SlabAllocator[T]) Reset() {
+	a.slab = nil
+	a.ArenaAllocator.Reset()
+}
+
+func (a *SlabAllocator[T]) Leak() {
+	a.slab = nil
+	a.ArenaAllocator.Leak()
+}
+
+func (a *SlabAllocator[T]) Free() {
+	a.slab = nil
+	a.ArenaAllocator.Free()
+}
+
+func (a *SlabAllocator[T]) AllocStats() AllocStats { return AllocStats{Chunks: a.chunks} }
+
+// FreeListAllocator is a SlabAllocator that recycles the nodes of released
+// trees through a free list, threaded through their Left pointers, and
+// serves new nodes from it before allocating from the arena. The arena
+// grows only when the free list is empty, so it reaches minalloc, and is
+// freed, less often.
+type FreeListAllocator[T any] struct {
+	SlabAllocator[T]
+	free       *Tree[T]
+	gets, hits int
+}
+
+// NewFreeListAllocator returns a free list allocator with a fresh arena,
+// allocating chunks of chunkNodes nodes.
+func NewFreeListAllocator[T any](chunkNodes int) *FreeListAllocator[T] {
+	return &FreeListAllocator[T]{SlabAllocator: *NewSlabAllocator[T](chunkNodes)}
+}
+
+func (a *FreeListAllocator[T]) NewTreeNode() *Tree[T] {
+	a.gets++
+	if t := a.free; t != nil {
+		a.hits++
+		a.free = t.Left
+		*t = Tree[T]{}
+		return t
+	}
+	return a.SlabAllocator.NewTreeNode()
+}
+
+// ReleaseTree puts every node of t on the free list.
+func (a *FreeListAllocator[T]) ReleaseTree(t *Tree[T]) {
+	if t == nil {
+		return
+	}
+	a.ReleaseTree(t.Left)
+	a.ReleaseTree(t.Right)
+	t.Left, t.Right = a.free, nil
+	a.free = t
+}
+
+// The free nodes live in the arena, so the free list goes with it.
+
+func (a *FreeListAllocator[T]) Reset() {
+	a.free = nil
+	a.SlabAllocator.Reset()
+}
+
+func (a *FreeListAllocator[T]) Leak() {
+	a.free = nil
+	a.SlabAllocator.Leak()
+}
+
+func (a *FreeListAllocator[T]) Free() {
+	a.free = nil
+	a.SlabAllocator.Free()
+}
+
+func (a *FreeListAllocator[T]) AllocStats() AllocStats {
+	return AllocStats{Gets: a.gets, Hits: a.hits, Chunks: a.chunks}
+}
+
+// TreeBuilder is implemented by allocators that build a whole tree at once
+// instead of one node at a time.
+type TreeBuilder[T any] interface {
+	BuildTree(depth int) *Tree[T]
+}
+
+// maxPreallocNodes caps the size of a single PreallocAllocator slice, so
+// that very deep trees are spread over several slices instead of one
+// multi-gigabyte allocation.
+const maxPreallocNodes = 1 << 20
+
+// PreallocAllocator builds a complete tree from as few arena.MakeSlice calls
+// as possible, wiring Left and Right by index arithmetic: the children of
+// node i are nodes 2i+1 and 2i+2.
+type PreallocAllocator[T any] struct {
+	ArenaAllocator[T]
+	chunks int
+}
+
+// NewPreallocAllocator returns a preallocating allocator with a fresh arena.
+func NewPreallocAllocator[T any]() *PreallocAllocator[T] {
+	return &PreallocAllocator[T]{ArenaAllocator: *NewArenaAllocator[T]()}
+}
+
+func (a *PreallocAllocator[T]) BuildTree(depth int) *Tree[T] {
+	n := 1<<(depth+1) - 1
+	slices := make([][]Tree[T], 0, (n+maxPreallocNodes-1)/maxPreallocNodes)
+	for remaining := n; remaining > 0; remaining -= maxPreallocNodes {
+		size := remaining
+		if size > maxPreallocNodes {
+			size = maxPreallocNodes
+		}
+		slices = append(slices, arenaMakeSlice[Tree[T]](a.arena, size, size))
+		a.chunks++
+	}
+	node := func(i int) *Tree[T] {
+		return &slices[i/maxPreallocNodes][i%maxPreallocNodes]
+	}
+	for i := 0; 2*i+2 < n; i++ {
+		t := node(i)
+		t.Left = node(2*i + 1)
+		t.Right = node(2*i + 2)
+	}
+	return node(0)
+}
+
+func (a *PreallocAllocator[T]) AllocStats() AllocStats { return AllocStats{Chunks: a.chunks} }
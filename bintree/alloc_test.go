@@ -0,0 +1,113 @@
+package bintree
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestAllocatedBytes(t *testing.T) {
+	const depth = 10
+	nodes := 1<<(depth+1) - 1
+	t.Run("struct{}", func(t *testing.T) { testAllocatedBytes[struct{}](t, depth, nodes) })
+	t.Run("int64", func(t *testing.T) { testAllocatedBytes[int64](t, depth, nodes) })
+	t.Run("[64]byte", func(t *testing.T) { testAllocatedBytes[[64]byte](t, depth, nodes) })
+}
+
+func testAllocatedBytes[T any](t *testing.T, depth, nodes int) {
+	nodeSize := int(unsafe.Sizeof(Tree[T]{}))
+	const chunkNodes = 1000
+	chunks := (nodes + chunkNodes - 1) / chunkNodes
+
+	allocs := []struct {
+		name string
+		a    Allocator[T]
+		want int
+	}{
+		{"arena", NewArenaAllocator[T](), nodes * nodeSize},
+		{"prealloc", NewPreallocAllocator[T](), nodes * nodeSize},
+		// The slab allocator counts whole chunks, used or not.
+		{"slab", NewSlabAllocator[T](chunkNodes), chunks * chunkNodes * nodeSize},
+	}
+	for _, alloc := range allocs {
+		t.Run(alloc.name, func(t *testing.T) {
+			a := alloc.a
+			defer a.Free()
+
+			NewTree(depth, a)
+			if got := allocatedBytes(a, -1); got != alloc.want {
+				t.Errorf("allocated %d bytes for depth %d, want %d", got, depth, alloc.want)
+			}
+			a.Reset()
+			if got := allocatedBytes(a, -1); got != 0 {
+				t.Errorf("allocated %d bytes after Reset, want 0", got)
+			}
+		})
+	}
+}
+
+func TestAllocatedBytesUnwrap(t *testing.T) {
+	a := &payloadAllocator[string]{Allocator: NewArenaAllocator[string](), fill: fillString}
+	defer a.Free()
+
+	const depth = 4
+	NewTree(depth, Allocator[string](a))
+	want := (1<<(depth+1) - 1) * int(unsafe.Sizeof(Tree[string]{}))
+	if got := allocatedBytes[string](a, -1); got != want {
+		t.Errorf("allocated %d bytes, want %d", got, want)
+	}
+	if got := allocatedBytes[string](HeapAllocator[string]{}, -1); got != -1 {
+		t.Errorf("heap allocator counted %d bytes, want the fallback", got)
+	}
+}
+
+func TestFreeListAllocator(t *testing.T) {
+	const depth = 10
+	nodes := 1<<(depth+1) - 1
+	a := NewFreeListAllocator[int64](1024)
+	defer a.Free()
+
+	tree := NewTree(depth, Allocator[int64](a))
+	tree.Value = 42
+	grown := a.AllocatedBytes()
+	a.ReleaseTree(tree)
+
+	tree = NewTree(depth, Allocator[int64](a))
+	if got := tree.Count(); got != nodes {
+		t.Fatalf("Count() = %d, want %d", got, nodes)
+	}
+	if tree.Value != 0 {
+		t.Errorf("recycled node has value %d, want 0", tree.Value)
+	}
+	if got := a.AllocatedBytes(); got != grown {
+		t.Errorf("arena grew from %d to %d bytes building a tree from the free list", grown, got)
+	}
+	if s := a.AllocStats(); s.Gets != 2*nodes || s.Hits != nodes {
+		t.Errorf("AllocStats() = %+v, want %d gets and %d hits", s, 2*nodes, nodes)
+	}
+
+	a.Reset()
+	NewTree(depth, Allocator[int64](a))
+	if s := a.AllocStats(); s.Hits != nodes {
+		t.Errorf("reused %d nodes after Reset, want none", s.Hits-nodes)
+	}
+}
+
+func TestArenaFreeElapsed(t *testing.T) {
+	a := NewSlabAllocator[struct{}](64)
+	NewTree[struct{}](10, a)
+	if got := freeElapsed[struct{}](a); got != 0 {
+		t.Errorf("freeElapsed before any free = %v", got)
+	}
+	a.Reset()
+	afterReset := freeElapsed[struct{}](a)
+	if afterReset <= 0 {
+		t.Errorf("freeElapsed after Reset = %v, want positive", afterReset)
+	}
+	a.Free()
+	if got := freeElapsed[struct{}](a); got <= afterReset {
+		t.Errorf("freeElapsed after Free = %v, want more than %v", got, afterReset)
+	}
+	if got := freeElapsed[struct{}](HeapAllocator[struct{}]{}); got != 0 {
+		t.Errorf("freeElapsed of the heap allocator = %v", got)
+	}
+}
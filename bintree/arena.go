@@ -0,0 +1,38 @@
+package bintree
+
+import (
+	"arena"
+	"unsafe"
+)
+
+// countingArena wraps an arena, counting the bytes requested from it, so
+// that the minalloc recycling decision and the MB column reflect what was
+// actually allocated rather than an assumed node size.
+type countingArena struct {
+	arena *arena.Arena
+	bytes int
+}
+
+func newCountingArena() *countingArena {
+	return &countingArena{arena: arena.NewArena()}
+}
+
+// AllocatedBytes returns the number of bytes requested from the arena.
+func (c *countingArena) AllocatedBytes() int { return c.bytes }
+
+// Free frees the arena.
+func (c *countingArena) Free() { c.arena.Free() }
+
+// arenaNew is arena.New, counting the size of T.
+func arenaNew[T any](c *countingArena) *T {
+	var zero T
+	c.bytes += int(unsafe.Sizeof(zero))
+	return arena.New[T](c.arena)
+}
+
+// arenaMakeSlice is arena.MakeSlice, counting the size of the backing array.
+func arenaMakeSlice[T any](c *countingArena, len, cap int) []T {
+	var zero T
+	c.bytes += cap * int(unsafe.Sizeof(zero))
+	return arena.MakeSlice[T](c.arena, len, cap)
+}
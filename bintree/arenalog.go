@@ -0,0 +1,140 @@
+package bintree
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// arenaLogEvent is an event in the lifecycle of an arena, logged with
+// Config.ArenaLog.
+type arenaLogEvent struct {
+	at    time.Time
+	owner string
+	depth int // 0 if the owner has not started a depth
+	kind  string
+
+	// bytes and trees are what was allocated from a freed or leaked arena,
+	// and lived is how long it lived.
+	bytes, trees int
+	lived        time.Duration
+}
+
+// arenaLog buffers the arena events of one goroutine, which passes them to
+// the run's arenaLogSink once it is done, so that logging takes no lock and
+// writes nothing while the trees are built. All methods of a nil arenaLog do
+// nothing, which is what the run uses without Config.ArenaLog.
+type arenaLog struct {
+	owner  string
+	depth  int
+	alive  bool
+	born   time.Time
+	events []arenaLogEvent
+	sink   *arenaLogSink
+}
+
+// arenaLogSink collects the events of all the arenaLogs of a run.
+type arenaLogSink struct {
+	mu      sync.Mutex
+	events  []arenaLogEvent
+	workers atomic.Int32
+}
+
+// newArenaLog returns the log of the arenas of owner, which builds trees of
+// depth, or 0 if it is not known yet, or nil without Config.ArenaLog.
+func (r *runner[T]) newArenaLog(owner string, depth int) *arenaLog {
+	if r.cfg.ArenaLog == nil {
+		return nil
+	}
+	return &arenaLog{owner: owner, depth: depth, sink: &r.arenaLog}
+}
+
+// newWorkerArenaLog returns the log of a new per-depth worker, numbering
+// the workers in the order they start, or nil without Config.ArenaLog.
+func (r *runner[T]) newWorkerArenaLog() *arenaLog {
+	if r.cfg.ArenaLog == nil {
+		return nil
+	}
+	return r.newArenaLog(fmt.Sprintf("worker %d", r.arenaLog.workers.Add(1)), 0)
+}
+
+// setDepth records that the owner has started building trees of depth,
+// which the arena it created before knowing it is logged with too.
+func (l *arenaLog) setDepth(depth int) {
+	if l == nil {
+		return
+	}
+	if l.depth == 0 && l.alive && len(l.events) > 0 {
+		l.events[len(l.events)-1].depth = depth
+	}
+	l.depth = depth
+}
+
+// created logs the creation of an arena if arenas is positive, as it is for
+// allocators backed by one.
+func (l *arenaLog) created(arenas int) {
+	if l == nil || arenas == 0 {
+		return
+	}
+	l.born = time.Now()
+	l.alive = true
+	l.events = append(l.events, arenaLogEvent{at: l.born, owner: l.owner, depth: l.depth, kind: "created"})
+}
+
+// freed logs that the arena logged as created last was freed after bytes
+// and trees were allocated from it, or left for the GC if leaked is set.
+func (l *arenaLog) freed(bytes, trees int, leaked bool) {
+	if l == nil || !l.alive {
+		return
+	}
+	now := time.Now()
+	kind := "freed"
+	if leaked {
+		kind = "leaked"
+	}
+	l.alive = false
+	l.events = append(l.events, arenaLogEvent{at: now, owner: l.owner, depth: l.depth, kind: kind,
+		bytes: bytes, trees: trees, lived: now.Sub(l.born)})
+}
+
+// flush passes the events logged so far to the sink.
+func (l *arenaLog) flush() {
+	if l == nil || len(l.events) == 0 {
+		return
+	}
+	l.sink.mu.Lock()
+	l.sink.events = append(l.sink.events, l.events...)
+	l.sink.mu.Unlock()
+	l.events = nil
+}
+
+// log logs the events of every flushed log to l in the order they
+// happened, each timestamped when it happened.
+func (s *arenaLogSink) log(l *slog.Logger) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.SliceStable(s.events, func(i, j int) bool { return s.events[i].at.Before(s.events[j].at) })
+	ctx := context.Background()
+	for _, e := range s.events {
+		if !l.Enabled(ctx, slog.LevelInfo) {
+			break
+		}
+		rec := slog.NewRecord(e.at, slog.LevelInfo, "arena "+e.kind, 0)
+		rec.AddAttrs(slog.String("owner", e.owner))
+		if e.depth > 0 {
+			rec.AddAttrs(slog.Int("depth", e.depth))
+		}
+		if e.kind != "created" {
+			rec.AddAttrs(slog.Int("bytes", e.bytes), slog.Int("trees", e.trees), slog.Duration("lived", e.lived))
+		}
+		if err := l.Handler().Handle(ctx, rec); err != nil {
+			return err
+		}
+	}
+	s.events = nil
+	return nil
+}
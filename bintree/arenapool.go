@@ -0,0 +1,181 @@
+package bintree
+
+import (
+	"arena"
+	"sync"
+)
+
+// ArenaPool recycles arenas across goroutines. An arena taken with Get is
+// owned by the caller until it is returned with Put, which frees it once it
+// has allocated more than the pool's budget and otherwise keeps it for any
+// goroutine's next Get. ArenaPool is safe for concurrent use.
+type ArenaPool struct {
+	budget int
+
+	mu sync.Mutex
+	// allocated holds the bytes allocated from every arena the pool has
+	// handed out and not freed, whether idle or in use.
+	allocated map[*arena.Arena]int
+	inUse     map[*arena.Arena]bool
+	idle      []*arena.Arena
+	stats     ArenaPoolStats
+
+	// events, if set, is called with the arenas the pool creates and
+	// frees, and the bytes allocated from the freed ones.
+	events func(created, freed, freedBytes int)
+}
+
+// ArenaPoolStats holds the counters of an ArenaPool.
+type ArenaPoolStats struct {
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+	Frees  int `json:"frees"`
+}
+
+// NewArenaPool returns a pool that frees arenas once more than budget bytes
+// have been allocated from them.
+func NewArenaPool(budget int) *ArenaPool {
+	return &ArenaPool{
+		budget:    budget,
+		allocated: make(map[*arena.Arena]int),
+		inUse:     make(map[*arena.Arena]bool),
+	}
+}
+
+// Get returns an idle arena from the pool, or a new one if there are none.
+func (p *ArenaPool) Get() *arena.Arena {
+	a, _ := p.get()
+	return a
+}
+
+// get is Get, also reporting whether the arena was recycled.
+func (p *ArenaPool) get() (a *arena.Arena, hit bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.idle); n > 0 {
+		a = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.stats.Hits++
+		hit = true
+	} else {
+		a = arena.NewArena()
+		p.allocated[a] = 0
+		p.stats.Misses++
+		p.event(1, 0, 0)
+	}
+	p.inUse[a] = true
+	return a, hit
+}
+
+// Put returns a, from which allocatedBytes were allocated since it was taken
+// with Get, to the pool. Nothing allocated from a may be used afterwards.
+// Put panics if a is not in use, such as when it is returned twice, which
+// would otherwise free it twice.
+func (p *ArenaPool) Put(a *arena.Arena, allocatedBytes int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inUse[a] {
+		panic("bintree: ArenaPool.Put of an arena that is not in use")
+	}
+	delete(p.inUse, a)
+	p.allocated[a] += allocatedBytes
+	if p.allocated[a] > p.budget {
+		p.free(a)
+		return
+	}
+	p.idle = append(p.idle, a)
+}
+
+// Close frees the idle arenas. Arenas still in use are freed when they are
+// Put after Close.
+func (p *ArenaPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range p.idle {
+		p.free(a)
+	}
+	p.idle = nil
+	p.budget = -1
+}
+
+// free frees a, which must not be in use. p.mu must be held.
+func (p *ArenaPool) free(a *arena.Arena) {
+	p.event(0, 1, p.allocated[a])
+	delete(p.allocated, a)
+	a.Free()
+	p.stats.Frees++
+}
+
+func (p *ArenaPool) event(created, freed, freedBytes int) {
+	if p.events != nil {
+		p.events(created, freed, freedBytes)
+	}
+}
+
+// Stats returns the pool's counters.
+func (p *ArenaPool) Stats() ArenaPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// ArenaPoolAllocator allocates tree nodes from arenas taken from an
+// ArenaPool, returning the arena to the pool and taking another on Reset.
+type ArenaPoolAllocator[T any] struct {
+	pool   *ArenaPool
+	arena  *countingArena
+	arenas int
+	stats  AllocStats
+}
+
+// NewArenaPoolAllocator returns an allocator with an arena from pool.
+func NewArenaPoolAllocator[T any](pool *ArenaPool) *ArenaPoolAllocator[T] {
+	a := &ArenaPoolAllocator[T]{pool: pool}
+	a.get()
+	return a
+}
+
+func (a *ArenaPoolAllocator[T]) get() {
+	ar, hit := a.pool.get()
+	a.arena = &countingArena{arena: ar}
+	a.stats.Gets++
+	if hit {
+		a.stats.Hits++
+	} else {
+		a.arenas++
+	}
+}
+
+func (a *ArenaPoolAllocator[T]) NewTreeNode() *Tree[T] {
+	return arenaNew[Tree[T]](a.arena)
+}
+
+func (a *ArenaPoolAllocator[T]) Reset() {
+	a.pool.Put(a.arena.arena, a.arena.AllocatedBytes())
+	a.get()
+}
+
+func (a *ArenaPoolAllocator[T]) Free() {
+	if a.arena != nil {
+		a.pool.Put(a.arena.arena, a.arena.AllocatedBytes())
+		a.arena = nil
+	}
+}
+
+// Arenas returns how many new arenas the pool created for the allocator.
+func (a *ArenaPoolAllocator[T]) Arenas() int { return a.arenas }
+
+func (a *ArenaPoolAllocator[T]) MakeBytes(n int) []byte {
+	return arenaMakeSlice[byte](a.arena, n, n)
+}
+
+// AllocatedBytes returns the bytes allocated since the arena was taken from
+// the pool.
+func (a *ArenaPoolAllocator[T]) AllocatedBytes() int {
+	if a.arena == nil {
+		return 0
+	}
+	return a.arena.AllocatedBytes()
+}
+
+func (a *ArenaPoolAllocator[T]) AllocStats() AllocStats { return a.stats }
@@ -0,0 +1,72 @@
+package bintree
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestArenaPoolFreesOnce(t *testing.T) {
+	const (
+		workers = 8
+		depth   = 8
+		rounds  = 50
+	)
+	// A budget of about three trees makes the pool both recycle and free.
+	budget := 3 * (1<<(depth+1) - 1) * int(unsafe.Sizeof(Tree[int64]{}))
+	pool := NewArenaPool(budget)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := NewArenaPoolAllocator[int64](pool)
+			defer a.Free()
+			for j := 0; j < rounds; j++ {
+				if got, want := NewTree[int64](depth, a).Count(), 1<<(depth+1)-1; got != want {
+					t.Errorf("count = %d, want %d", got, want)
+				}
+				a.Reset()
+			}
+		}()
+	}
+	wg.Wait()
+	pool.Close()
+
+	stats := pool.Stats()
+	if stats.Hits == 0 || stats.Frees == 0 {
+		t.Errorf("stats = %+v, want both hits and frees", stats)
+	}
+	// Every arena the pool created is freed exactly once.
+	if stats.Frees != stats.Misses {
+		t.Errorf("freed %d arenas, created %d", stats.Frees, stats.Misses)
+	}
+	if got, want := stats.Hits+stats.Misses, workers*(rounds+1); got != want {
+		t.Errorf("got %d arenas, want %d", got, want)
+	}
+}
+
+func TestArenaPoolDoublePut(t *testing.T) {
+	pool := NewArenaPool(1 << 20)
+	defer pool.Close()
+
+	a := pool.Get()
+	pool.Put(a, 0)
+	defer func() {
+		if recover() == nil {
+			t.Error("second Put of the same arena did not panic")
+		}
+	}()
+	pool.Put(a, 0)
+}
+
+func TestArenaPoolPutAfterClose(t *testing.T) {
+	pool := NewArenaPool(1 << 20)
+	a := pool.Get()
+	pool.Close()
+	pool.Put(a, 0)
+	if stats := pool.Stats(); stats.Frees != 1 {
+		t.Errorf("freed %d arenas after Close, want 1", stats.Frees)
+	}
+}
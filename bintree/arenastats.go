@@ -0,0 +1,112 @@
+package bintree
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ArenaStats summarizes the lifecycle of the arenas of a run across all its
+// goroutines.
+type ArenaStats struct {
+	Created int `json:"created"`
+	Freed   int `json:"freed"`
+
+	// FreedBytes is the total of the bytes allocated from the freed arenas
+	// before they were freed.
+	FreedBytes int64 `json:"freed_bytes"`
+
+	// MaxAlive is the largest number of arenas created and not yet freed
+	// at any one time.
+	MaxAlive int `json:"max_alive"`
+
+	// Leaked is the number of arenas that were never freed, leaving them
+	// for the GC, in the never free mode.
+	Leaked int `json:"leaked,omitempty"`
+
+	// FreeElapsed is the time spent in arena.Free across all goroutines,
+	// except by the arena pool, which is not timed.
+	FreeElapsed time.Duration `json:"free_ns,omitempty"`
+
+	// wall is the wall time of the run, for the text output.
+	wall time.Duration
+}
+
+// AvgFreedBytes returns the average number of bytes allocated from an arena
+// before it was freed.
+func (s ArenaStats) AvgFreedBytes() float64 {
+	if s.Freed == 0 {
+		return 0
+	}
+	return float64(s.FreedBytes) / float64(s.Freed)
+}
+
+// String formats s as a line of text output.
+func (s ArenaStats) String() string {
+	line := fmt.Sprintf("          arena summary    created: %-6d freed: %-6d avg MB per arena: %0.2f max alive: %d",
+		s.Created, s.Freed, s.AvgFreedBytes()/(1<<20), s.MaxAlive)
+	if s.Leaked > 0 {
+		line += fmt.Sprintf(" leaked: %d", s.Leaked)
+	}
+	if s.FreeElapsed > 0 {
+		line += fmt.Sprintf(" free ms: %0.1f", float64(s.FreeElapsed)/float64(time.Millisecond))
+		if s.wall > 0 {
+			line += fmt.Sprintf(" (%0.2f%% of wall)", float64(s.FreeElapsed)/float64(s.wall)*100)
+		}
+	}
+	return line
+}
+
+// arenaTracker records the arenas created and freed by the goroutines of a
+// run. Every event updates the number of live arenas atomically, so the
+// maximum reflects the order in which the goroutines actually created and
+// freed them.
+type arenaTracker struct {
+	created, freed, freedBytes atomic.Int64
+	alive, maxAlive            atomic.Int64
+	freeElapsed                atomic.Int64
+}
+
+// freeTime records that d was spent freeing arenas.
+func (t *arenaTracker) freeTime(d time.Duration) {
+	if d > 0 {
+		t.freeElapsed.Add(int64(d))
+	}
+}
+
+// free records that n arenas have been freed after bytes were allocated
+// from them. Callers replacing an arena record the free before the create,
+// as the old arena is freed first.
+func (t *arenaTracker) free(n, bytes int) {
+	t.freed.Add(int64(n))
+	t.freedBytes.Add(int64(bytes))
+	t.alive.Add(-int64(n))
+}
+
+// create records that n arenas have been created.
+func (t *arenaTracker) create(n int) {
+	t.created.Add(int64(n))
+	alive := t.alive.Add(int64(n))
+	for {
+		max := t.maxAlive.Load()
+		if alive <= max || t.maxAlive.CompareAndSwap(max, alive) {
+			return
+		}
+	}
+}
+
+// stats returns the tracked statistics, or nil if no arena was created.
+func (t *arenaTracker) stats() *ArenaStats {
+	if t.created.Load() == 0 {
+		return nil
+	}
+	return &ArenaStats{
+		Created:    int(t.created.Load()),
+		Freed:      int(t.freed.Load()),
+		FreedBytes: t.freedBytes.Load(),
+		MaxAlive:   int(t.maxAlive.Load()),
+		Leaked:     int(t.alive.Load()),
+
+		FreeElapsed: time.Duration(t.freeElapsed.Load()),
+	}
+}
@@ -0,0 +1,37 @@
+package bintree
+
+import "unsafe"
+
+// BallastTypes lists the supported Config.BallastType names. A bytes
+// ballast is pointer-free, so the GC never scans it; a pointers ballast is
+// full of pointers the GC has to follow on every cycle.
+var BallastTypes = []string{"bytes", "pointers"}
+
+func validBallastType(typ string) bool {
+	for _, t := range BallastTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// ballastNode is an element of a pointerful ballast.
+type ballastNode struct {
+	next *ballastNode
+	_    uintptr
+}
+
+// newBallast allocates a live heap ballast of mb MB of the given type. The
+// caller must keep the result reachable for as long as the ballast is needed.
+func newBallast(mb int, typ string) any {
+	size := mb << 20
+	if typ == "pointers" {
+		nodes := make([]ballastNode, size/int(unsafe.Sizeof(ballastNode{})))
+		for i := 1; i < len(nodes); i++ {
+			nodes[i-1].next = &nodes[i]
+		}
+		return nodes
+	}
+	return make([]byte, size)
+}
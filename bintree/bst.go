@@ -0,0 +1,57 @@
+package bintree
+
+import (
+	"fmt"
+	"time"
+)
+
+// bstTrees builds a binary search tree by inserting cfg.BSTKeys
+// pseudo-random keys, or as many as a complete tree of depth has nodes if
+// BSTKeys is not set, then walks it in order, adding the time taken to the
+// worker's countElapsed, to validate that the keys come out sorted and that
+// none are missing.
+func bstTrees(w *treeWorker[int64], depth int) (nodes, bytes int) {
+	n := w.r.cfg.BSTKeys
+	if n <= 0 {
+		n = 1<<(depth+1) - 1
+	}
+	var root *Tree[int64]
+	for i := 0; i < n; i++ {
+		root = insertKey(root, w.rng.Int63(), w.alloc)
+	}
+
+	walkStart := time.Now()
+	nodes, sorted := walkInOrder(root)
+	w.countElapsed += time.Since(walkStart)
+	if !sorted {
+		panic(fmt.Sprintf("bintree: in-order walk of a binary search tree of %d keys is not sorted", n))
+	}
+	if nodes != n {
+		panic(fmt.Sprintf("bintree: binary search tree has %d nodes, inserted %d", nodes, n))
+	}
+	return nodes, nodes * w.r.nodeSize
+}
+
+// walkInOrder walks the binary search tree rooted at root in order,
+// returning the number of nodes and whether their keys were sorted. It keeps
+// its own stack, as a tree built from unlucky keys can be as deep as it has
+// nodes.
+func walkInOrder(root *Tree[int64]) (nodes int, sorted bool) {
+	var stack []*Tree[int64]
+	var prev int64
+	sorted = true
+	for t := root; t != nil || len(stack) > 0; {
+		for ; t != nil; t = t.Left {
+			stack = append(stack, t)
+		}
+		t = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if nodes > 0 && t.Value < prev {
+			sorted = false
+		}
+		prev = t.Value
+		nodes++
+		t = t.Right
+	}
+	return nodes, sorted
+}
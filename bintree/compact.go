@@ -0,0 +1,67 @@
+package bintree
+
+import (
+	"fmt"
+	"time"
+)
+
+// CompactStats describes the trees a worker copied from its scratch arena
+// into its survivor arena with Config.Compact.
+type CompactStats struct {
+	Copies int `json:"copies"`
+	Nodes  int `json:"nodes"`
+
+	// Bytes is how much the survivor arena grew.
+	Bytes   int           `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// NodesPerSec returns the copy throughput.
+func (s CompactStats) NodesPerSec() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Nodes) / s.Elapsed.Seconds()
+}
+
+// String formats s for the end of a line of text output.
+func (s CompactStats) String() string {
+	return fmt.Sprintf("copies: %d copy nodes/sec: %.0f survivor MB: %0.1f",
+		s.Copies, s.NodesPerSec(), float64(s.Bytes)/(1<<20))
+}
+
+// compactLast copies the most recent tree into the survivor arena, which
+// it creates on first use, before the scratch arena holding the tree is
+// reset.
+func (w *treeWorker[T]) compactLast() {
+	if w.last == nil {
+		return
+	}
+	if w.survivor == nil {
+		w.survivor = newCountingArena()
+		w.r.arenaEvents(1, 0, 0)
+	}
+	start := time.Now()
+	before := w.survivor.AllocatedBytes()
+	copyTree(w.survivor, w.last)
+	w.compact.Elapsed += time.Since(start)
+
+	bytes := w.survivor.AllocatedBytes() - before
+	w.compact.Copies++
+	w.compact.Nodes += bytes / w.r.nodeSize
+	w.compact.Bytes += bytes
+	w.r.live.add(bytes)
+	w.last = nil
+}
+
+// freeSurvivor frees the survivor arena, if any.
+func (w *treeWorker[T]) freeSurvivor() {
+	if w.survivor == nil {
+		return
+	}
+	bytes := w.survivor.AllocatedBytes()
+	w.r.arenaEvents(0, 1, bytes)
+	w.r.live.add(-bytes)
+	w.survivor.Free()
+	w.survivor = nil
+}
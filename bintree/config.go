@@ -0,0 +1,470 @@
+package bintree
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"unsafe"
+)
+
+// Config configures a benchmark run.
+type Config struct {
+	// MaxDepth is the depth of the long-lived tree and the deepest
+	// short-lived trees. The stretch tree is one level deeper.
+	MaxDepth int
+
+	// MinDepth is the depth of the shallowest short-lived trees. MaxDepth
+	// is raised to at least MinDepth+2.
+	MinDepth int
+
+	// MinAllocMB controls how frequently each worker goroutine calls Free:
+	// upon completing a tree, a worker reuses its arena unless the arena has
+	// completed more than MinAllocMB of allocations.
+	MinAllocMB float64
+
+	// Iterations, if positive, is the number of trees built at every depth
+	// instead of 1<<(MaxDepth-depth+MinDepth).
+	Iterations int
+
+	// IterScale multiplies the number of trees built at each depth.
+	IterScale float64
+
+	// Warmup and WarmupTime, if positive, have each depth first build
+	// Warmup trees, or build trees for WarmupTime, that are not reported.
+	// The allocator is reset between the warmup and the measured trees.
+	Warmup     int
+	WarmupTime time.Duration
+
+	// BenchTime, if positive, has each depth build trees for this long
+	// instead of a fixed number of iterations, like go test -benchtime.
+	BenchTime time.Duration
+
+	// Workers, if positive, is the size of the worker pool building the
+	// per-depth trees. Zero means one goroutine per depth.
+	Workers int
+
+	// Alloc names the allocation strategy; see AllocatorNames.
+	Alloc string
+
+	// ArenaPool has the per-depth workers share their arenas through an
+	// ArenaPool, which frees each one once it has allocated more than
+	// MinAllocMB. It requires the arena allocation strategy.
+	ArenaPool bool
+
+	// FreeMode names when the per-depth workers free their arenas; see
+	// FreeModes. The default mb frees an arena once it has allocated more
+	// than MinAllocMB, end keeps one arena per worker until the worker is
+	// done, never replaces the arena at the same threshold but leaves the
+	// old one for the GC instead of freeing it, and interval frees the arena
+	// every FreeEvery iterations.
+	FreeMode string
+
+	// FreeEvery is the number of iterations between frees in the interval
+	// free mode.
+	FreeEvery int
+
+	// HeapFrac, if positive, is the probability with which each node is
+	// allocated on the heap instead of from the arena, drawn from a
+	// generator seeded with Seed. It requires the arena or slab allocator.
+	HeapFrac float64
+
+	// RootIndex has each per-depth worker of the tree workload keep the
+	// roots of the trees built since its arena was last freed in a slice on
+	// the heap, so that the GC scans heap memory pointing into the arena.
+	// It requires an allocator with arenas.
+	RootIndex bool
+
+	// LeakCheck sets finalizers on a sample of the trees of the tree
+	// workload and checks that all of them were collected at the end of the
+	// run, to make sure the benchmark retains none of them. It only applies
+	// to runs with the heap allocator, such as the heap pass of a
+	// comparison.
+	LeakCheck bool
+
+	// ChunkNodes is the number of nodes per chunk for the slab and freelist
+	// allocators.
+	ChunkNodes int
+
+	// Padding is the size in bytes of a byte array embedded in each node to
+	// grow the node size; see Paddings.
+	Padding int
+
+	// Payload names the type of each node's Value; see Payloads. The string
+	// and *int64 payloads are populated so the GC has pointers to scan. The
+	// parent payload points each node of a complete tree at its parent,
+	// which is checked from a sample of leaves unless NoValidate is set.
+	// It cannot be combined with Padding.
+	Payload string
+
+	// Build names how complete trees are built; see BuildModes. Allocators
+	// that build whole trees at once, such as prealloc, ignore it.
+	Build string
+
+	// Layout names the order in which the nodes of complete trees are
+	// allocated; see Layouts. The default postorder allocates both subtrees
+	// before their parent, levelorder allocates the tree level by level
+	// and is always built iteratively. Like Build, it does not apply to
+	// allocators that build whole trees at once.
+	Layout string
+
+	// Fanout, if greater than 1, splits the trees of each depth across
+	// Fanout goroutines, each with its own allocator, and merges their
+	// results into one per depth.
+	Fanout int
+
+	// LockThreads locks each per-depth worker goroutine to its OS thread
+	// for its lifetime, to tell how much of the variance between runs comes
+	// from goroutines migrating between threads.
+	LockThreads bool
+
+	// Workload names the per-depth work; see Workloads. The random workload
+	// builds unbalanced trees by inserting as many pseudo-random keys as a
+	// complete tree of the same depth has nodes, storing the keys in an
+	// int64 payload. The bytes workload allocates byte buffers of
+	// SliceSizes instead of nodes. The mutate workload keeps one tree per
+	// worker alive and replaces one of its subtrees each iteration. The
+	// serialize workload encodes each tree into a byte buffer allocated,
+	// and grown, from the same allocator, and the deserialize workload
+	// decodes such an encoding, made once per depth, into a tree allocated
+	// from the allocator. The bst workload inserts BSTKeys pseudo-random
+	// keys like the random workload, then walks the tree in order to
+	// validate it. The stretch and long-lived trees are always complete.
+	Workload string
+
+	// Branch is the number of children per node of the trees of the nary
+	// workload, which allocates the nodes and their child slices from the
+	// arena, or from the heap with the heap allocator. Each depth builds
+	// trees as deep as gives about as many nodes as a binary tree of that
+	// depth.
+	Branch int
+
+	// MutateLevel is the level below the root of the subtrees replaced by
+	// the mutate workload. Zero means half the depth of the tree, rounded
+	// up, and levels beyond it replace leaves.
+	MutateLevel int
+
+	// ListLen is the length of the lists built by the list workload. If not
+	// positive, each list has as many nodes as a complete tree of the depth.
+	ListLen int
+
+	// BSTKeys is the number of keys inserted into each tree by the bst
+	// workload. If not positive, each tree has as many nodes as a complete
+	// tree of the depth.
+	BSTKeys int
+
+	// SliceSizes are the sizes in bytes of the buffers allocated by the bytes
+	// workload, used in turn.
+	SliceSizes []int
+
+	// Seed seeds the pseudo-random numbers of randomized workloads and of
+	// HeapFrac.
+	Seed int64
+
+	// BallastMB, if positive, is the size of a live heap ballast retained
+	// for the whole run, to give the GC more live heap to scan.
+	BallastMB int
+
+	// BallastType is the type of the ballast; see BallastTypes.
+	BallastType string
+
+	// MemLimit, if positive, is a soft memory limit in bytes set with
+	// debug.SetMemoryLimit for the duration of the run.
+	MemLimit int64
+
+	// CloneLongLived deep-copies the long-lived tree out of its arena and
+	// frees the arena as soon as the tree is built.
+	CloneLongLived bool
+
+	// Compact has each per-depth worker copy its most recent tree into a
+	// survivor arena before it resets its scratch arena, as a manual
+	// generational collector would. It requires the arena allocation
+	// strategy and the tree workload.
+	Compact bool
+
+	// NoValidate skips checking that every complete tree has 2^(depth+1)-1
+	// nodes, for benchmarking the absolute minimum work.
+	NoValidate bool
+
+	// Single allocates only the stretch tree, in a single goroutine unless
+	// Fanout splits the trees of SingleIters.
+	Single bool
+
+	// SingleDepth, if positive, is the depth of the trees built in single
+	// mode instead of the depth of the stretch tree.
+	SingleDepth int
+
+	// SingleIters is the number of trees built one after the other in single
+	// mode, recycling the arena every MinAllocMB.
+	SingleIters int
+
+	// SerialPhases builds the stretch tree, then the long-lived tree, then
+	// the per-depth trees, one phase after the other, so that the memory
+	// used by each phase can be told apart. By default they run concurrently.
+	SerialPhases bool
+
+	// Format is the output format; see Formats.
+	Format string
+
+	// Quiet suppresses the result output.
+	Quiet bool
+
+	// Flags, if set, lists the command-line flags that produced the
+	// config, for the run metadata.
+	Flags []string
+
+	// Env, if set, lists the environment variables, as KEY=value, that
+	// set flag defaults for the config, for the run metadata.
+	Env []string
+
+	// Label, if not empty, labels the output of the run.
+	Label string
+
+	// Progress, if not nil, has the run publish its per-depth progress.
+	Progress *Progress
+
+	// ArenaLog, if not nil, logs every arena created, freed or leaked by
+	// the per-depth workers and for the stretch and long-lived trees, with
+	// the bytes and trees allocated from it and how long it lived. The
+	// events are buffered per goroutine and logged at the end of the run,
+	// timestamped when they happened. The arenas of an ArenaPool are not
+	// logged.
+	ArenaLog *slog.Logger
+
+	// Cancel, if not nil, stops the run early when closed. Workers stop
+	// before their next tree, and Run returns the partial results.
+	Cancel <-chan struct{}
+
+	// Deadline, if not zero, stops the run as closing Cancel does once it
+	// passes, and Run returns ErrTimeout. A run started after it stops
+	// before building any tree.
+	Deadline time.Time
+}
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() Config {
+	return Config{
+		MaxDepth:    21,
+		MinDepth:    4,
+		MinAllocMB:  1,
+		IterScale:   1,
+		Alloc:       "arena",
+		FreeMode:    "mb",
+		ChunkNodes:  4096,
+		Payload:     "none",
+		Build:       "recursive",
+		Layout:      "postorder",
+		SingleIters: 1,
+		Workload:    "tree",
+		Branch:      4,
+		SliceSizes:  []int{1024},
+		BallastType: "bytes",
+		Seed:        1,
+		Format:      "text",
+	}
+}
+
+// Validate reports whether cfg is a valid configuration.
+func (cfg *Config) Validate() error {
+	if !validAllocator(cfg.Alloc) {
+		return fmt.Errorf("unknown allocator %q", cfg.Alloc)
+	}
+	if cfg.ArenaPool && cfg.Alloc != "arena" {
+		return fmt.Errorf("the arena pool requires the arena allocator, not %q", cfg.Alloc)
+	}
+	if !validFreeMode(cfg.FreeMode) {
+		return fmt.Errorf("unknown free mode %q, must be one of %q", cfg.FreeMode, FreeModes)
+	}
+	switch {
+	case cfg.FreeMode == "interval" && cfg.FreeEvery < 1:
+		return errors.New("the interval free mode needs a free interval of at least 1")
+	case cfg.FreeMode != "interval" && cfg.FreeEvery != 0:
+		return fmt.Errorf("a free interval requires the interval free mode, not %s", cfg.FreeMode)
+	case cfg.FreeMode == "never" && cfg.Alloc != "arena" && cfg.Alloc != "slab" && cfg.Alloc != "freelist":
+		return fmt.Errorf("the never free mode requires an allocator with arenas, not %q", cfg.Alloc)
+	case cfg.ArenaPool && cfg.FreeMode != "mb":
+		return fmt.Errorf("the arena pool frees by MB and cannot be combined with the %s free mode", cfg.FreeMode)
+	}
+	switch {
+	case cfg.HeapFrac < 0 || cfg.HeapFrac > 1:
+		return fmt.Errorf("heap fraction %g must be between 0 and 1", cfg.HeapFrac)
+	case cfg.HeapFrac > 0 && cfg.Alloc != "arena" && cfg.Alloc != "slab":
+		return fmt.Errorf("a heap fraction requires the arena or slab allocator, not %q", cfg.Alloc)
+	case cfg.HeapFrac > 0 && cfg.Workload == "bytes":
+		return errors.New("a heap fraction applies to nodes and cannot be combined with the bytes workload")
+	case cfg.Workload == "nary" && cfg.Branch < 2:
+		return errors.New("the nary workload needs a branch of at least 2")
+	case cfg.Workload == "nary" && cfg.Alloc != "arena" && cfg.Alloc != "heap":
+		return fmt.Errorf("the nary workload allocates from the arena or the heap, not with %s", cfg.Alloc)
+	case cfg.Workload == "nary" && (cfg.Padding != 0 || cfg.Payload != "none" || cfg.HeapFrac > 0):
+		return errors.New("the nary workload has no payload and cannot be combined with a padding, payload or heap fraction")
+	case cfg.MutateLevel < 0:
+		return errors.New("mutate level must not be negative")
+	case cfg.LeakCheck && cfg.Workload != "tree":
+		return fmt.Errorf("the leak check requires the tree workload, not %s", cfg.Workload)
+	case cfg.RootIndex && (cfg.Alloc != "arena" && cfg.Alloc != "slab" && cfg.Alloc != "freelist" || cfg.Workload != "tree"):
+		return fmt.Errorf("the root index requires an allocator with arenas and the tree workload, not %s and %s", cfg.Alloc, cfg.Workload)
+	}
+	if cfg.Payload == "parent" && (cfg.Compact || cfg.CloneLongLived) {
+		return errors.New("the parent payload cannot be combined with copying trees, which would not relink their parents")
+	}
+	if cfg.Compact && (cfg.Alloc != "arena" || cfg.Workload != "tree") {
+		return fmt.Errorf("compaction requires the arena allocator and the tree workload, not %s and %s", cfg.Alloc, cfg.Workload)
+	}
+	if !validFormat(cfg.Format) {
+		return fmt.Errorf("unknown format %q", cfg.Format)
+	}
+	if cfg.Format == "compat" && (cfg.Workload != "tree" || cfg.Single || cfg.MinDepth != 4 || cfg.Iterations > 0 ||
+		cfg.IterScale != 1 || cfg.BenchTime > 0) {
+		return errors.New("the compat format needs the tree workload with the reference depths and iterations")
+	}
+	if !validPadding(cfg.Padding) {
+		return fmt.Errorf("unsupported padding %d, must be one of %v", cfg.Padding, Paddings)
+	}
+	if !validPayload(cfg.Payload) {
+		return fmt.Errorf("unknown payload %q, must be one of %q", cfg.Payload, Payloads)
+	}
+	if cfg.Padding != 0 && cfg.Payload != "none" {
+		return errors.New("padding and payload cannot be combined")
+	}
+	if !validBuild(cfg.Build) {
+		return fmt.Errorf("unknown build %q, must be one of %q", cfg.Build, BuildModes)
+	}
+	if cfg.SingleDepth < 0 || cfg.SingleIters < 0 {
+		return errors.New("the single depth and iterations cannot be negative")
+	}
+	if !validLayout(cfg.Layout) {
+		return fmt.Errorf("unknown layout %q, must be one of %q", cfg.Layout, Layouts)
+	}
+	if cfg.Layout == "levelorder" && cfg.Build != "recursive" {
+		return fmt.Errorf("the levelorder layout cannot be combined with the %s build", cfg.Build)
+	}
+	if !validWorkload(cfg.Workload) {
+		return fmt.Errorf("unknown workload %q, must be one of %q", cfg.Workload, Workloads)
+	}
+	if int64Workload(cfg.Workload) && (cfg.Padding != 0 || cfg.Payload != "none" && cfg.Payload != "int64") {
+		return fmt.Errorf("the %s workload uses an int64 payload", cfg.Workload)
+	}
+	if cfg.Workload == "bytes" {
+		if len(cfg.SliceSizes) == 0 {
+			return errors.New("the bytes workload needs at least one slice size")
+		}
+		for _, size := range cfg.SliceSizes {
+			if size < 1 {
+				return fmt.Errorf("slice size %d must be at least 1", size)
+			}
+		}
+	}
+	if !validBallastType(cfg.BallastType) {
+		return fmt.Errorf("unknown ballast type %q, must be one of %q", cfg.BallastType, BallastTypes)
+	}
+	switch {
+	case cfg.MemLimit < 0:
+		return errors.New("memory limit must not be negative")
+	case cfg.BallastMB < 0:
+		return errors.New("ballast must not be negative")
+	case cfg.ChunkNodes < 1:
+		return errors.New("chunk nodes must be at least 1")
+	case cfg.MinDepth < 1:
+		return errors.New("min depth must be at least 1")
+	case cfg.MaxDepth < 1:
+		return errors.New("max depth must be at least 1")
+	case cfg.Iterations < 0:
+		return errors.New("iterations must not be negative")
+	case cfg.Workers < 0:
+		return errors.New("workers must not be negative")
+	case cfg.Fanout < 0:
+		return errors.New("fanout must not be negative")
+	case cfg.IterScale <= 0:
+		return errors.New("iteration scale must be positive")
+	case cfg.BenchTime < 0:
+		return errors.New("bench time must not be negative")
+	case cfg.BenchTime > 0 && cfg.Iterations > 0:
+		return errors.New("bench time and iterations cannot be combined")
+	case cfg.Warmup < 0 || cfg.WarmupTime < 0:
+		return errors.New("warmup must not be negative")
+	case cfg.Warmup > 0 && cfg.WarmupTime > 0:
+		return errors.New("warmup iterations and warmup time cannot be combined")
+	}
+	return nil
+}
+
+// minAllocBytes returns MinAllocMB in bytes.
+func (cfg *Config) minAllocBytes() int {
+	return int(cfg.MinAllocMB * (1 << 20))
+}
+
+// payloadName describes the node payload type.
+func (cfg *Config) payloadName() string {
+	if int64Workload(cfg.Workload) {
+		return "int64"
+	}
+	if cfg.Padding != 0 {
+		return fmt.Sprintf("[%d]byte", cfg.Padding)
+	}
+	return cfg.Payload
+}
+
+// NodeSize returns the size in bytes of the tree nodes of the run cfg
+// configures.
+func (cfg *Config) NodeSize() int {
+	switch {
+	case int64Workload(cfg.Workload):
+		return int(unsafe.Sizeof(Tree[int64]{}))
+	case cfg.Padding == 64:
+		return int(unsafe.Sizeof(Tree[[64]byte]{}))
+	case cfg.Padding == 256:
+		return int(unsafe.Sizeof(Tree[[256]byte]{}))
+	case cfg.Padding == 1024:
+		return int(unsafe.Sizeof(Tree[[1024]byte]{}))
+	}
+	switch cfg.Payload {
+	case "int64":
+		return int(unsafe.Sizeof(Tree[int64]{}))
+	case "[64]byte":
+		return int(unsafe.Sizeof(Tree[[64]byte]{}))
+	case "string":
+		return int(unsafe.Sizeof(Tree[string]{}))
+	case "*int64":
+		return int(unsafe.Sizeof(Tree[*int64]{}))
+	case "parent":
+		return int(unsafe.Sizeof(Tree[parentNode]{}))
+	default:
+		return int(unsafe.Sizeof(Tree[struct{}]{}))
+	}
+}
+
+// iterationCount returns how many trees of depth to build, honoring
+// Iterations and IterScale. It is always at least 1.
+func (cfg *Config) iterationCount(depth, minDepth, maxDepth int) int {
+	iterations := 1 << (maxDepth - depth + minDepth)
+	if cfg.Iterations > 0 {
+		iterations = cfg.Iterations
+	}
+	iterations = int(float64(iterations) * cfg.IterScale)
+	if iterations < 1 {
+		iterations = 1
+	}
+	return iterations
+}
+
+// Paddings lists the supported Config.Padding sizes.
+var Paddings = []int{0, 64, 256, 1024}
+
+func validPadding(padding int) bool {
+	for _, p := range Paddings {
+		if p == padding {
+			return true
+		}
+	}
+	return false
+}
+
+func validFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
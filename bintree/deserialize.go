@@ -0,0 +1,70 @@
+package bintree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// encodeTree returns the encoding of a complete tree of depth, as written by
+// treeEncoder, in a buffer on the regular heap.
+func encodeTree[T any](depth int) []byte {
+	e := treeEncoder[T]{alloc: HeapAllocator[T]{}}
+	e.encode(NewTree[T](depth, HeapAllocator[T]{}))
+	return e.buf[:e.n]
+}
+
+// treeDecoder decodes a tree encoded by treeEncoder, allocating its nodes
+// from an allocator.
+type treeDecoder[T any] struct {
+	alloc Allocator[T]
+	buf   []byte
+	nodes uint64
+}
+
+// decode decodes the next node and its subtrees, panicking if the encoding
+// is malformed.
+func (d *treeDecoder[T]) decode() *Tree[T] {
+	if len(d.buf) == 0 {
+		panic(fmt.Sprintf("bintree: encoded tree ends after %d nodes", d.nodes))
+	}
+	flags := d.buf[0]
+	index, n := binary.Uvarint(d.buf[1:])
+	if n <= 0 || index != d.nodes {
+		panic(fmt.Sprintf("bintree: encoded node %d has a bad index", d.nodes))
+	}
+	d.buf = d.buf[1+n:]
+	d.nodes++
+
+	t := allocTreeNode(d.alloc)
+	if flags&1 != 0 {
+		t.Left = d.decode()
+	}
+	if flags&2 != 0 {
+		t.Right = d.decode()
+	}
+	return t
+}
+
+// deserializeTrees decodes the worker's encoding of a complete tree of
+// depth, prepared by buildTrees before it starts the clock, into a tree
+// allocated from the worker's allocator, and counts it, adding the time
+// taken to count it to the worker's countElapsed.
+func deserializeTrees[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	d := treeDecoder[T]{alloc: w.alloc, buf: w.encoded}
+	tree := d.decode()
+	if len(d.buf) != 0 {
+		panic(fmt.Sprintf("bintree: %d bytes left over after decoding %d nodes", len(d.buf), d.nodes))
+	}
+
+	countStart := time.Now()
+	nodes = tree.Count()
+	w.countElapsed += time.Since(countStart)
+	if w.r.badCount(depth, nodes) {
+		w.countErrors++
+	}
+	if w.releaser != nil {
+		w.releaser.ReleaseTree(tree)
+	}
+	return nodes, nodes * w.r.nodeSize
+}
@@ -0,0 +1,78 @@
+package bintree
+
+import "fmt"
+
+// MemoryEstimate is the worst-case estimate of the memory a run needs if
+// everything it allocates is alive at once.
+type MemoryEstimate struct {
+	Depth int `json:"depth"`
+
+	// Stretch and LongLived are the sizes of the stretch and long-lived
+	// trees.
+	Stretch   int64 `json:"stretch_bytes"`
+	LongLived int64 `json:"long_lived_bytes"`
+
+	// Workers is the number of per-depth workers running at once, and
+	// Depths the sum of their budgets: an arena of MinAllocMB and the tree
+	// that takes it past that, for the deepest depths.
+	Workers int   `json:"workers"`
+	Depths  int64 `json:"depths_bytes"`
+}
+
+// Total returns the estimated bytes.
+func (e MemoryEstimate) Total() int64 {
+	return e.Stretch + e.LongLived + e.Depths
+}
+
+// Largest names the largest part of the estimate.
+func (e MemoryEstimate) Largest() string {
+	switch {
+	case e.Stretch >= e.LongLived && e.Stretch >= e.Depths:
+		return fmt.Sprintf("stretch tree of depth %d", e.Depth+1)
+	case e.LongLived >= e.Depths:
+		return fmt.Sprintf("long-lived tree of depth %d", e.Depth)
+	default:
+		return fmt.Sprintf("arena budgets of %d depth workers", e.Workers)
+	}
+}
+
+// String formats e for a line of text output.
+func (e MemoryEstimate) String() string {
+	mb := func(b int64) float64 { return float64(b) / (1 << 20) }
+	return fmt.Sprintf("estimated memory for depth %d: %0.1f MB (stretch tree %0.1f MB, long-lived tree %0.1f MB, "+
+		"%d depth workers %0.1f MB)", e.Depth, mb(e.Total()), mb(e.Stretch), mb(e.LongLived), e.Workers, mb(e.Depths))
+}
+
+// EstimateMemory returns the worst-case estimate of the memory the run cfg
+// configures needs.
+func (cfg *Config) EstimateMemory() MemoryEstimate {
+	maxDepth, depths := depthRange(cfg.MinDepth, cfg.MaxDepth)
+	nodeSize := int64(cfg.NodeSize())
+	treeBytes := func(depth int) int64 { return int64(1<<(depth+1)-1) * nodeSize }
+
+	e := MemoryEstimate{
+		Depth:     maxDepth,
+		Stretch:   treeBytes(maxDepth + 1),
+		LongLived: treeBytes(maxDepth),
+		Workers:   len(depths),
+	}
+	if cfg.Workers > 0 && cfg.Workers < e.Workers {
+		e.Workers = cfg.Workers
+	}
+	// The deepest depths have the largest trees.
+	for _, depth := range depths[len(depths)-e.Workers:] {
+		e.Depths += treeBytes(depth) + int64(cfg.minAllocBytes())
+	}
+	return e
+}
+
+// FitDepth returns the largest depth up to cfg.MaxDepth whose memory
+// estimate is within limit bytes, or 0 if none is.
+func (cfg Config) FitDepth(limit int64) int {
+	for ; cfg.MaxDepth >= 1; cfg.MaxDepth-- {
+		if cfg.EstimateMemory().Total() <= limit {
+			return cfg.MaxDepth
+		}
+	}
+	return 0
+}
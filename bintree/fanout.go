@@ -0,0 +1,112 @@
+package bintree
+
+import "time"
+
+// splitIterations splits iterations into fanout parts whose sizes differ by
+// at most one, the larger ones first, leaving out empty parts. A fanout
+// below 2 is a single part.
+func splitIterations(iterations, fanout int) []int {
+	if fanout < 2 {
+		return []int{iterations}
+	}
+	if fanout > iterations {
+		fanout = iterations
+	}
+	parts := make([]int, fanout)
+	for i := range parts {
+		parts[i] = iterations / fanout
+		if i < iterations%fanout {
+			parts[i]++
+		}
+	}
+	return parts
+}
+
+// mergeResults merges the results of the parts of a fanned out depth into
+// one. The parts ran concurrently, so the elapsed time is the longest of
+// theirs, and the time spent counting or freeing arenas is their share of
+// it. Parts canceled before their first tree are left out, marking the
+// result Partial; if all of them were, the result is the zero Result.
+func mergeResults(parts []Result) Result {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	var res Result
+	var elapsed, countElapsed, freeElapsed time.Duration
+	var alloc AllocStats
+	skipped := false
+	for _, p := range parts {
+		if p.Kind == "" {
+			skipped = true
+			continue
+		}
+		if res.Kind == "" {
+			res = Result{Kind: p.Kind, Depth: p.Depth, unit: p.unit, timed: p.timed,
+				inserts: p.inserts, decodes: p.decodes}
+		}
+		res.Iterations += p.Iterations
+		res.Arenas += p.Arenas
+		res.Nodes += p.Nodes
+		res.Bytes += p.Bytes
+		res.CountErrors += p.CountErrors
+		res.Partial = res.Partial || p.Partial
+		if p.Elapsed > res.Elapsed {
+			res.Elapsed = p.Elapsed
+		}
+		elapsed += p.Elapsed
+		countElapsed += p.CountElapsed
+		freeElapsed += p.FreeElapsed
+		if p.Alloc != nil {
+			alloc.Gets += p.Alloc.Gets
+			alloc.Hits += p.Alloc.Hits
+			alloc.Chunks += p.Alloc.Chunks
+			alloc.Heap += p.Alloc.Heap
+		}
+		if p.Mutate != nil {
+			if res.Mutate == nil {
+				res.Mutate = new(MutateStats)
+			}
+			res.Mutate.Replaced += p.Mutate.Replaced
+			res.Mutate.Rebuilds += p.Mutate.Rebuilds
+			res.Mutate.Allocated += p.Mutate.Allocated
+			res.Mutate.Garbage += p.Mutate.Garbage
+		}
+		if p.Serialize != nil {
+			if res.Serialize == nil {
+				res.Serialize = new(SerializeStats)
+			}
+			res.Serialize.Encoded += p.Serialize.Encoded
+			res.Serialize.Buffers += p.Serialize.Buffers
+			res.Serialize.Elapsed += p.Serialize.Elapsed
+		}
+		if p.Compact != nil {
+			if res.Compact == nil {
+				res.Compact = new(CompactStats)
+			}
+			res.Compact.Copies += p.Compact.Copies
+			res.Compact.Nodes += p.Compact.Nodes
+			res.Compact.Bytes += p.Compact.Bytes
+			res.Compact.Elapsed += p.Compact.Elapsed
+		}
+		if p.Latency != nil {
+			if res.Latency == nil {
+				res.Latency = &LatencyHistogram{Bounds: LatencyBounds}
+			}
+			res.Latency.merge(p.Latency)
+		}
+		res.latencySample = append(res.latencySample, p.latencySample...)
+		if p.MaxTree > res.MaxTree {
+			res.MaxTree = p.MaxTree
+		}
+	}
+	if skipped && res.Kind != "" {
+		res.Partial = true
+	}
+	if elapsed > 0 {
+		res.CountElapsed = time.Duration(float64(res.Elapsed) * float64(countElapsed) / float64(elapsed))
+		res.FreeElapsed = time.Duration(float64(res.Elapsed) * float64(freeElapsed) / float64(elapsed))
+	}
+	res.Alloc = alloc.orNil()
+	res.P50, res.P95, res.P99 = latencyQuantiles(res.latencySample)
+	return res
+}
@@ -0,0 +1,71 @@
+package bintree
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSplitIterations(t *testing.T) {
+	for _, tt := range []struct {
+		iterations, fanout int
+		want               []int
+	}{
+		{iterations: 16, fanout: 0, want: []int{16}},
+		{iterations: 16, fanout: 1, want: []int{16}},
+		{iterations: 16, fanout: 4, want: []int{4, 4, 4, 4}},
+		{iterations: 17, fanout: 4, want: []int{5, 4, 4, 4}},
+		{iterations: 19, fanout: 4, want: []int{5, 5, 5, 4}},
+		{iterations: 2, fanout: 4, want: []int{1, 1}},
+	} {
+		if got := splitIterations(tt.iterations, tt.fanout); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitIterations(%d, %d) = %v, want %v", tt.iterations, tt.fanout, got, tt.want)
+		}
+	}
+}
+
+func TestMergeResultsSkipped(t *testing.T) {
+	part := Result{Kind: KindDepth, Depth: 4, Iterations: 2, Nodes: 62, Elapsed: 1}
+	if res := mergeResults([]Result{part, part}); res.Partial || res.Iterations != 4 {
+		t.Errorf("merged complete parts: partial = %v, %d trees, want complete with 4", res.Partial, res.Iterations)
+	}
+	res := mergeResults([]Result{part, {}})
+	if !res.Partial || res.Iterations != 2 {
+		t.Errorf("merged with a part canceled before its first tree: partial = %v, %d trees, want partial with 2",
+			res.Partial, res.Iterations)
+	}
+	if res := mergeResults([]Result{{}, {}}); res.Kind != "" {
+		t.Errorf("merged parts that were all canceled = %+v, want the zero Result", res)
+	}
+}
+
+func TestRunFanout(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 10
+		cfg.Quiet = true
+		want, _, err := Run(cfg, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg.Fanout = 3
+		cfg.Workers = workers
+		got, _, err := Run(cfg, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("workers=%d: %d results with fanout, want %d", workers, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Depth != want[i].Depth || got[i].Iterations != want[i].Iterations || got[i].Nodes != want[i].Nodes {
+				t.Errorf("workers=%d: fanout result %d built %d trees of depth %d with %d nodes, want %d of depth %d with %d",
+					workers, i, got[i].Iterations, got[i].Depth, got[i].Nodes, want[i].Iterations, want[i].Depth, want[i].Nodes)
+			}
+		}
+		if g, w := SumResults(got), SumResults(want); g.Trees != w.Trees || g.Nodes != w.Nodes {
+			t.Errorf("workers=%d: fanout built %d trees with %d nodes, want %d with %d", workers, g.Trees, g.Nodes, w.Trees, w.Nodes)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package bintree
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// HeapSnapshot holds the heap's fragmentation signals at one point of a run:
+// at its start, as each depth completes, and at its end.
+type HeapSnapshot struct {
+	Name string `json:"name"`
+
+	// Elapsed is the time since the start of the run.
+	Elapsed time.Duration `json:"elapsed_ns"`
+
+	HeapSys      uint64 `json:"heap_sys_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapIdle     uint64 `json:"heap_idle_bytes"`
+	HeapReleased uint64 `json:"heap_released_bytes"`
+}
+
+// Retained returns the bytes of idle heap spans the runtime has not returned
+// to the OS: memory it is sitting on but not using.
+func (s HeapSnapshot) Retained() uint64 {
+	return s.HeapIdle - s.HeapReleased
+}
+
+// fragRecorder takes HeapSnapshots during a run.
+type fragRecorder struct {
+	start     time.Time
+	mu        sync.Mutex
+	snapshots []HeapSnapshot
+}
+
+// startFrag starts recording, taking the snapshot of the start of the run.
+func startFrag() *fragRecorder {
+	f := &fragRecorder{start: time.Now()}
+	f.snapshot("start")
+	return f
+}
+
+// snapshot records the heap's fragmentation signals, naming them name.
+func (f *fragRecorder) snapshot(name string) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshots = append(f.snapshots, HeapSnapshot{
+		Name:         name,
+		Elapsed:      time.Since(f.start),
+		HeapSys:      ms.HeapSys,
+		HeapInuse:    ms.HeapInuse,
+		HeapIdle:     ms.HeapIdle,
+		HeapReleased: ms.HeapReleased,
+	})
+}
+
+// depthDone returns a function to call as each of the parts of depth
+// completes, which takes the snapshot of the depth after the last one.
+func (f *fragRecorder) depthDone(depth, parts int) func() {
+	var mu sync.Mutex
+	return func() {
+		mu.Lock()
+		parts--
+		last := parts == 0
+		mu.Unlock()
+		if last {
+			f.snapshot(fmt.Sprintf("depth %d", depth))
+		}
+	}
+}
+
+// printFrag writes the heap snapshots as a text table: how much of the heap
+// was in use, how much the runtime retained unused, and how much it had
+// returned to the OS.
+func printFrag(w io.Writer, label string, snapshots []HeapSnapshot) {
+	mb := func(b uint64) float64 { return float64(b) / (1 << 20) }
+	fmt.Fprintf(w, "%s%-28s %10s %12s %14s %13s %13s\n", label, "fragmentation", "ms", "HeapSys MB", "HeapInuse MB",
+		"retained MB", "released MB")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "%s%-28s %10.1f %12.1f %14.1f %13.1f %13.1f\n",
+			label,
+			s.Name,
+			float64(s.Elapsed)/float64(time.Millisecond),
+			mb(s.HeapSys),
+			mb(s.HeapInuse),
+			mb(s.Retained()),
+			mb(s.HeapReleased))
+	}
+}
@@ -0,0 +1,323 @@
+package bintree
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GCStats summarizes the GC work done during a run.
+type GCStats struct {
+	NumGC         uint32        `json:"num_gc"`
+	PauseTotal    time.Duration `json:"pause_total_ns"`
+	TotalAlloc    uint64        `json:"total_alloc_bytes"`
+	Mallocs       uint64        `json:"mallocs"`
+	PeakHeapInuse uint64        `json:"peak_heap_inuse_bytes"`
+
+	// GCCPU is the CPU time the runtime estimates the GC spent, in its
+	// background workers, assists and pauses, which is where scanning
+	// pointers into or out of arenas shows up.
+	GCCPU time.Duration `json:"gc_cpu_ns,omitempty"`
+
+	// FinalHeapAlloc is HeapAlloc at the end of the run, which shows how
+	// far the heap grew when the GC is disabled.
+	FinalHeapAlloc uint64 `json:"final_heap_alloc_bytes"`
+
+	// MaxPause is the longest GC pause during the run, and RecentPauses
+	// the last few pauses, most recent first. Both are empty if no GC
+	// completed during the run.
+	MaxPause     time.Duration   `json:"max_pause_ns,omitempty"`
+	RecentPauses []time.Duration `json:"recent_pauses_ns,omitempty"`
+
+	// PeakRSSGrowth is the peak resident set size during the run less the
+	// resident set size at its start, where the platform reports it.
+	PeakRSSGrowth uint64 `json:"peak_rss_growth_bytes,omitempty"`
+
+	// PeakLive is the peak number of bytes the run had requested and not
+	// yet released, for comparison with PeakRSSGrowth.
+	PeakLive uint64 `json:"peak_live_bytes,omitempty"`
+
+	// PeakRSS is the peak resident set size of the process during the run,
+	// as reported by the OS. Outside Linux the peak cannot be reset, so it
+	// is the peak of the process so far.
+	PeakRSS uint64 `json:"peak_rss_bytes,omitempty"`
+
+	// RSSAfterFree is the resident set size right after the run freed all
+	// its allocators, which shows whether freed arenas were returned to the
+	// OS. It is only available on Linux.
+	RSSAfterFree uint64 `json:"rss_after_free_bytes,omitempty"`
+
+	// Arenas summarizes the arenas created and freed during the run, if
+	// the allocation strategy uses arenas.
+	Arenas *ArenaStats `json:"arenas,omitempty"`
+
+	// ArenaPool holds the counters of the arena pool, if one was used.
+	ArenaPool *ArenaPoolStats `json:"arena_pool,omitempty"`
+
+	// Leaks holds the results of the leak check, if one was done.
+	Leaks *LeakStats `json:"leaks,omitempty"`
+
+	// Phases holds the memory deltas of the phases of the run.
+	Phases []PhaseStats `json:"phases,omitempty"`
+
+	// Pauses is the distribution of the stop-the-world GC pauses of the
+	// run, from the runtime/metrics pause histogram, or nil if the runtime
+	// does not have one. Its buckets are those of a LatencyHistogram, each
+	// pause counted at the upper bound of its runtime bucket.
+	Pauses *LatencyHistogram `json:"pauses,omitempty"`
+
+	// MemoryClasses are the runtime/metrics /memory/classes/ metrics at the
+	// start and end of the run, which show where the arena chunks are
+	// accounted.
+	MemoryClasses []MemoryClass `json:"memory_classes,omitempty"`
+
+	// Heap holds the heap's fragmentation signals at the start of the run,
+	// as each depth completed, and at its end.
+	Heap []HeapSnapshot `json:"heap_snapshots,omitempty"`
+}
+
+// String formats s as a line of text output.
+func (s GCStats) String() string {
+	line := fmt.Sprintf("             gc summary    gcs: %-8d pause: %-8v alloc MB: %0.1f mallocs: %d peak HeapInuse MB: %0.1f",
+		s.NumGC,
+		s.PauseTotal.Round(time.Microsecond),
+		float64(s.TotalAlloc)/(1<<20),
+		s.Mallocs,
+		float64(s.PeakHeapInuse)/(1<<20))
+	if s.GCCPU > 0 {
+		line += fmt.Sprintf(" gc cpu: %v", s.GCCPU.Round(time.Microsecond))
+	}
+	if len(s.RecentPauses) > 0 {
+		recent := make([]string, len(s.RecentPauses))
+		for i, p := range s.RecentPauses {
+			recent[i] = p.Round(time.Microsecond).String()
+		}
+		line += fmt.Sprintf(" max pause: %v recent pauses: %s",
+			s.MaxPause.Round(time.Microsecond),
+			strings.Join(recent, ","))
+	}
+	if s.PeakRSS > 0 {
+		line += fmt.Sprintf(" peak RSS MB: %0.1f", float64(s.PeakRSS)/(1<<20))
+	}
+	if s.RSSAfterFree > 0 {
+		line += fmt.Sprintf(" RSS after free MB: %0.1f", float64(s.RSSAfterFree)/(1<<20))
+	}
+	return line
+}
+
+// BytesString formats s as the text output line comparing the peak RSS
+// growth with the peak live bytes requested, for the bytes workload.
+func (s GCStats) BytesString() string {
+	line := fmt.Sprintf("          bytes summary    peak live MB: %0.1f peak RSS growth MB: %0.1f",
+		float64(s.PeakLive)/(1<<20),
+		float64(s.PeakRSSGrowth)/(1<<20))
+	if s.PeakLive > 0 && s.PeakRSSGrowth > 0 {
+		line += fmt.Sprintf(" overhead: %0.1f%%", (float64(s.PeakRSSGrowth)/float64(s.PeakLive)-1)*100)
+	}
+	return line
+}
+
+// String formats s as a line of text output.
+func (s ArenaPoolStats) String() string {
+	return fmt.Sprintf("     arena pool summary    hits: %-8d misses: %-6d frees: %d", s.Hits, s.Misses, s.Frees)
+}
+
+// gcRecorder captures MemStats at the start of a run and samples the peak
+// HeapInuse and RSS until stopped.
+type gcRecorder struct {
+	before    runtime.MemStats
+	beforeGC  debug.GCStats
+	beforeCPU time.Duration
+	beforeRSS uint64
+	stopPeak  func() (heapInuse, rss uint64)
+
+	// beforeClasses and afterClasses are the memory class samples of the
+	// start and end of the run, allocated up front.
+	beforeClasses, afterClasses []metrics.Sample
+
+	// beforePauses is the GC pause histogram at the start of the run.
+	beforePauses []metrics.Sample
+}
+
+// startGCStats starts recording GC statistics.
+func startGCStats() *gcRecorder {
+	r := &gcRecorder{beforeClasses: newMemoryClassSamples(), afterClasses: newMemoryClassSamples()}
+	metrics.Read(r.beforeClasses)
+	r.beforePauses = []metrics.Sample{{Name: pauseMetric}}
+	metrics.Read(r.beforePauses)
+	runtime.ReadMemStats(&r.before)
+	debug.ReadGCStats(&r.beforeGC)
+	r.beforeCPU = readGCCPU()
+	r.beforeRSS, _ = readRSS()
+	r.stopPeak = samplePeaks(10 * time.Millisecond)
+	return r
+}
+
+// stop stops recording and returns the statistics for the recorded interval.
+func (r *gcRecorder) stop() GCStats {
+	peak, peakRSS := r.stopPeak()
+	metrics.Read(r.afterClasses)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	s := GCStats{
+		NumGC:         after.NumGC - r.before.NumGC,
+		PauseTotal:    time.Duration(after.PauseTotalNs - r.before.PauseTotalNs),
+		TotalAlloc:    after.TotalAlloc - r.before.TotalAlloc,
+		Mallocs:       after.Mallocs - r.before.Mallocs,
+		PeakHeapInuse: peak,
+		GCCPU:         readGCCPU() - r.beforeCPU,
+
+		FinalHeapAlloc: after.HeapAlloc,
+		MemoryClasses:  memoryClasses(r.beforeClasses, r.afterClasses),
+		Pauses:         r.pauses(),
+	}
+	if peakRSS > r.beforeRSS {
+		s.PeakRSSGrowth = peakRSS - r.beforeRSS
+	}
+
+	// Pause holds the recent pauses, most recent first; only the first
+	// n of them happened during the run, and there may be none.
+	var afterGC debug.GCStats
+	debug.ReadGCStats(&afterGC)
+	n := int(afterGC.NumGC - r.beforeGC.NumGC)
+	if n > len(afterGC.Pause) {
+		n = len(afterGC.Pause)
+	}
+	for i, p := range afterGC.Pause[:n] {
+		if p > s.MaxPause {
+			s.MaxPause = p
+		}
+		if i < maxRecentPauses {
+			s.RecentPauses = append(s.RecentPauses, p)
+		}
+	}
+	return s
+}
+
+// pauseMetric is the runtime/metrics histogram of GC pauses. Newer
+// toolchains deprecate /gc/pauses:seconds in favor of the first one.
+var pauseMetric = func() string {
+	for _, d := range metrics.All() {
+		if d.Name == "/sched/pauses/total/gc:seconds" {
+			return d.Name
+		}
+	}
+	return "/gc/pauses:seconds"
+}()
+
+// pauses returns the histogram of the GC pauses since the recorder started,
+// or nil if the runtime does not support pauseMetric.
+func (r *gcRecorder) pauses() *LatencyHistogram {
+	after := []metrics.Sample{{Name: pauseMetric}}
+	metrics.Read(after)
+	if after[0].Value.Kind() != metrics.KindFloat64Histogram {
+		return nil
+	}
+	before := r.beforePauses[0].Value.Float64Histogram()
+	hist := after[0].Value.Float64Histogram()
+	h := &LatencyHistogram{Bounds: LatencyBounds}
+	for i, n := range hist.Counts {
+		if i < len(before.Counts) {
+			n -= before.Counts[i]
+		}
+		if n == 0 {
+			continue
+		}
+		upper := hist.Buckets[i+1]
+		if math.IsInf(upper, 1) {
+			upper = hist.Buckets[i]
+		}
+		h.add(time.Duration(upper*float64(time.Second)), int(n))
+	}
+	return h
+}
+
+// gcCPUMetric is the runtime/metrics estimate of the CPU time spent by the GC.
+const gcCPUMetric = "/cpu/classes/gc/total:cpu-seconds"
+
+// readGCCPU returns the CPU time the GC has spent so far, or 0 if the
+// runtime does not support the metric.
+func readGCCPU() time.Duration {
+	sample := []metrics.Sample{{Name: gcCPUMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return time.Duration(sample[0].Value.Float64() * float64(time.Second))
+}
+
+// GCPercent returns the current GC target percentage, as set by GOGC or
+// debug.SetGCPercent, or -1 if the GC is disabled.
+func GCPercent() int {
+	p := debug.SetGCPercent(100)
+	debug.SetGCPercent(p)
+	return p
+}
+
+// maxRecentPauses is the number of GCStats.RecentPauses kept.
+const maxRecentPauses = 5
+
+// samplePeaks polls HeapInuse and the RSS every interval until the returned
+// stop function is called, which returns the peak values observed.
+func samplePeaks(interval time.Duration) (stop func() (heapInuse, rss uint64)) {
+	var (
+		wg      sync.WaitGroup
+		peak    uint64
+		peakRSS uint64
+		done    = make(chan struct{})
+	)
+	sample := func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if ms.HeapInuse > peak {
+			peak = ms.HeapInuse
+		}
+		if rss, ok := readRSS(); ok && rss > peakRSS {
+			peakRSS = rss
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sample()
+			case <-done:
+				sample()
+				return
+			}
+		}
+	}()
+
+	return func() (uint64, uint64) {
+		close(done)
+		wg.Wait()
+		return peak, peakRSS
+	}
+}
+
+// byteGauge tracks a number of live bytes and its peak, safely for
+// concurrent use.
+type byteGauge struct {
+	live, peak atomic.Int64
+}
+
+func (g *byteGauge) add(n int) {
+	live := g.live.Add(int64(n))
+	for {
+		peak := g.peak.Load()
+		if live <= peak || g.peak.CompareAndSwap(peak, live) {
+			return
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package bintree
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGCPauses(t *testing.T) {
+	runtime.GC()
+	r := startGCStats()
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	s := r.stop()
+	if s.Pauses == nil {
+		t.Skip("the runtime has no GC pause histogram")
+	}
+	// Pauses before the recorder started are not counted.
+	if n := s.Pauses.total(); n < 3 || n > 2*int(s.NumGC) {
+		t.Errorf("recorded %d pauses for %d GCs", n, s.NumGC)
+	}
+}
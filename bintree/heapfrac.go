@@ -0,0 +1,58 @@
+package bintree
+
+// heapFracAllocator wraps an allocator with arenas, allocating each node on
+// the heap instead with probability frac, so that arena nodes point at heap
+// nodes and heap nodes at arena nodes. It draws from its own xorshift
+// generator, seeded with Config.Seed, so that every allocator of a run makes
+// the same sequence of choices.
+type heapFracAllocator[T any] struct {
+	Allocator[T]
+
+	// threshold is frac scaled to the 53 bits compared against the
+	// generator, so that a frac of 1 always picks the heap.
+	threshold uint64
+	rng       uint64
+	heap      int
+}
+
+// withHeapFrac wraps the allocators returned by newAlloc in a
+// heapFracAllocator if cfg.HeapFrac is set.
+func withHeapFrac[T any](cfg *Config, newAlloc func() Allocator[T]) func() Allocator[T] {
+	if cfg.HeapFrac <= 0 {
+		return newAlloc
+	}
+	threshold := uint64(cfg.HeapFrac * (1 << 53))
+	return func() Allocator[T] {
+		return &heapFracAllocator[T]{
+			Allocator: newAlloc(),
+			threshold: threshold,
+			rng:       uint64(cfg.Seed)*0x9e3779b97f4a7c15 | 1,
+		}
+	}
+}
+
+func (a *heapFracAllocator[T]) NewTreeNode() *Tree[T] {
+	a.rng ^= a.rng << 13
+	a.rng ^= a.rng >> 7
+	a.rng ^= a.rng << 17
+	if a.rng>>11 < a.threshold {
+		a.heap++
+		return &Tree[T]{}
+	}
+	return a.Allocator.NewTreeNode()
+}
+
+func (a *heapFracAllocator[T]) ReleaseTree(t *Tree[T]) {
+	if r, ok := a.Allocator.(TreeReleaser[T]); ok {
+		r.ReleaseTree(t)
+	}
+}
+
+func (a *heapFracAllocator[T]) AllocStats() AllocStats {
+	s := allocStats(a.Allocator)
+	s.Heap = a.heap
+	return s
+}
+
+// Unwrap returns the wrapped allocator.
+func (a *heapFracAllocator[T]) Unwrap() Allocator[T] { return a.Allocator }
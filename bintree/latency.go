@@ -0,0 +1,188 @@
+package bintree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LatencyBounds are the upper bounds of the buckets of a LatencyHistogram,
+// in 1-2-5 steps per decade.
+var LatencyBounds = []time.Duration{
+	time.Microsecond, 2 * time.Microsecond, 5 * time.Microsecond,
+	10 * time.Microsecond, 20 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 200 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// latencyBuckets is the number of LatencyHistogram buckets: one per bound,
+// and one for the durations of at least the last bound.
+const latencyBuckets = 23
+
+// LatencyHistogram counts the time taken by each iteration of a depth,
+// including any arena reset before it, in log-scaled buckets. Recording a
+// duration does not allocate.
+type LatencyHistogram struct {
+	// Bounds is LatencyBounds, for the JSON output.
+	Bounds []time.Duration `json:"bounds_ns"`
+
+	// Counts[i] is the number of durations below Bounds[i] and not below
+	// the bound before it; the last bucket counts the rest.
+	Counts [latencyBuckets]int `json:"counts"`
+
+	Max time.Duration `json:"max_ns"`
+}
+
+// record adds d to the histogram.
+func (h *LatencyHistogram) record(d time.Duration) {
+	h.add(d, 1)
+}
+
+// add adds n durations of d to the histogram.
+func (h *LatencyHistogram) add(d time.Duration, n int) {
+	i := 0
+	for i < len(LatencyBounds) && d >= LatencyBounds[i] {
+		i++
+	}
+	h.Counts[i] += n
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// merge adds the durations recorded by o to h.
+func (h *LatencyHistogram) merge(o *LatencyHistogram) {
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+}
+
+// total returns the number of durations recorded.
+func (h *LatencyHistogram) total() int {
+	n := 0
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile of
+// the recorded durations, capped at Max, or 0 if there are none.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	rank := int(q * float64(h.total()))
+	seen := 0
+	for i, c := range h.Counts {
+		seen += c
+		if seen > rank && c > 0 {
+			if i < len(LatencyBounds) && LatencyBounds[i] < h.Max {
+				return LatencyBounds[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+// String formats h for a line of text output: the upper bounds of the
+// buckets holding its quantiles, then the counts of the non-empty buckets
+// keyed by their upper bounds. The bounds are labeled as such so they are
+// not read as the sampled quantiles of the depth's own line.
+func (h *LatencyHistogram) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "p50<=%-8v p99<=%-8v p99.9<=%-8v max: %-10v",
+		h.Quantile(0.5).Round(time.Microsecond),
+		h.Quantile(0.99).Round(time.Microsecond),
+		h.Quantile(0.999).Round(time.Microsecond),
+		h.Max.Round(time.Microsecond))
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		if i < len(LatencyBounds) {
+			fmt.Fprintf(&b, " <%v:%d", LatencyBounds[i], c)
+		} else {
+			fmt.Fprintf(&b, " >=%v:%d", LatencyBounds[i-1], c)
+		}
+	}
+	return b.String()
+}
+
+// reservoirSize is the number of durations a latencyReservoir keeps.
+const reservoirSize = 1024
+
+// latencyReservoir keeps a uniform sample of the durations it records, with
+// Vitter's algorithm R, to estimate their quantiles without allocating. It
+// draws from its own xorshift generator so as not to disturb the worker's.
+type latencyReservoir struct {
+	samples [reservoirSize]time.Duration
+	n       int
+	max     time.Duration
+	rng     uint64
+}
+
+// reset empties the reservoir and seeds its generator.
+func (r *latencyReservoir) reset(seed int64) {
+	r.n, r.max = 0, 0
+	r.rng = uint64(seed)*0x9e3779b97f4a7c15 | 1
+}
+
+// record adds d to the sample.
+func (r *latencyReservoir) record(d time.Duration) {
+	if d > r.max {
+		r.max = d
+	}
+	if r.n < reservoirSize {
+		r.samples[r.n] = d
+	} else {
+		r.rng ^= r.rng << 13
+		r.rng ^= r.rng >> 7
+		r.rng ^= r.rng << 17
+		if j := r.rng % uint64(r.n+1); j < reservoirSize {
+			r.samples[j] = d
+		}
+	}
+	r.n++
+}
+
+// sample returns a copy of the durations kept.
+func (r *latencyReservoir) sample() []time.Duration {
+	n := r.n
+	if n > reservoirSize {
+		n = reservoirSize
+	}
+	return append([]time.Duration(nil), r.samples[:n]...)
+}
+
+// quantiles returns the 50th, 95th and 99th percentiles of the sample, and
+// the largest duration recorded, or zeros if there were none.
+func (r *latencyReservoir) quantiles() (p50, p95, p99, max time.Duration) {
+	p50, p95, p99 = latencyQuantiles(r.sample())
+	return p50, p95, p99, r.max
+}
+
+// latencyQuantiles sorts sample and returns its 50th, 95th and 99th
+// percentiles, or zeros if it is empty.
+func latencyQuantiles(sample []time.Duration) (p50, p95, p99 time.Duration) {
+	if len(sample) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(sample, func(i, j int) bool { return sample[i] < sample[j] })
+	at := func(q float64) time.Duration { return sample[int(q*float64(len(sample)-1))] }
+	return at(0.50), at(0.95), at(0.99)
+}
+
+// roundLatency rounds d for the text output, to a microsecond once it is
+// large enough for the nanoseconds to be noise.
+func roundLatency(d time.Duration) time.Duration {
+	if d >= 100*time.Microsecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(10 * time.Nanosecond)
+}
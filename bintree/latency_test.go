@@ -0,0 +1,71 @@
+package bintree
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	if len(LatencyBounds)+1 != latencyBuckets {
+		t.Fatalf("%d bounds for %d buckets", len(LatencyBounds), latencyBuckets)
+	}
+
+	var h LatencyHistogram
+	for i := 0; i < 98; i++ {
+		h.record(3 * time.Microsecond)
+	}
+	h.record(time.Microsecond)
+	h.record(30 * time.Second)
+
+	for _, tt := range []struct {
+		bucket, count int
+	}{
+		{bucket: 1, count: 1},  // [1µs, 2µs)
+		{bucket: 2, count: 98}, // [2µs, 5µs)
+		{bucket: latencyBuckets - 1, count: 1},
+	} {
+		if got := h.Counts[tt.bucket]; got != tt.count {
+			t.Errorf("bucket %d counted %d, want %d", tt.bucket, got, tt.count)
+		}
+	}
+	if got, want := h.Quantile(0.5), 5*time.Microsecond; got != want {
+		t.Errorf("p50 = %v, want %v", got, want)
+	}
+	if got, want := h.Quantile(0.999), 30*time.Second; got != want {
+		t.Errorf("p99.9 = %v, want the max %v", got, want)
+	}
+	if got := (&LatencyHistogram{}).Quantile(0.5); got != 0 {
+		t.Errorf("empty p50 = %v, want 0", got)
+	}
+}
+
+func TestLatencyReservoir(t *testing.T) {
+	var r latencyReservoir
+	r.reset(1)
+	if p50, _, _, max := r.quantiles(); p50 != 0 || max != 0 {
+		t.Errorf("empty quantiles = %v, %v, want zeros", p50, max)
+	}
+
+	const n = 100 * reservoirSize
+	for i := 1; i <= n; i++ {
+		r.record(time.Duration(i))
+	}
+	p50, p95, p99, max := r.quantiles()
+	if max != n {
+		t.Errorf("max = %d, want %d", max, n)
+	}
+	// The sample is uniform, so the quantiles should be within a few
+	// percent of the exact ones.
+	for _, q := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"p50", p50, n / 2},
+		{"p95", p95, n * 95 / 100},
+		{"p99", p99, n * 99 / 100},
+	} {
+		if diff := q.got - q.want; diff < -n/20 || diff > n/20 {
+			t.Errorf("%s = %d, want about %d", q.name, q.got, q.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package bintree
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// leakCheckEvery is the sampling interval of Config.LeakCheck: it tracks the
+// first tree each worker builds at a depth and every leakCheckEvery-th one
+// after it.
+const leakCheckEvery = 10000
+
+// leakCheckGCs is the number of collections the leak check waits for the
+// sampled roots to be finalized before reporting the rest as survivors.
+const leakCheckGCs = 5
+
+// LeakStats are the results of Config.LeakCheck.
+type LeakStats struct {
+	Sampled   int          `json:"sampled"`
+	Survivors []LeakedRoot `json:"survivors,omitempty"`
+}
+
+// LeakedRoot identifies a sampled tree root that was still reachable after
+// the run: the depth of the tree and the index of the iteration of its
+// worker that built it.
+type LeakedRoot struct {
+	Depth     int `json:"depth"`
+	Iteration int `json:"iteration"`
+}
+
+// String formats s as a line of text output.
+func (s LeakStats) String() string {
+	return fmt.Sprintf("     leak check summary    sampled: %-6d collected: %-6d survived: %d",
+		s.Sampled, s.Sampled-len(s.Survivors), len(s.Survivors))
+}
+
+// String formats l as a line of text output.
+func (l LeakedRoot) String() string {
+	return fmt.Sprintf("                   LEAK    root of depth %d iteration %d was not collected", l.Depth, l.Iteration)
+}
+
+// leakChecker sets finalizers on a sample of the tree roots of a heap run,
+// to check once the run is over that the benchmark did not retain them.
+type leakChecker struct {
+	mu      sync.Mutex
+	samples []*leakSample
+}
+
+type leakSample struct {
+	root      LeakedRoot
+	collected atomic.Bool
+}
+
+// sampled reports whether the tree built by iteration of a worker is
+// tracked.
+func (c *leakChecker) sampled(iteration int) bool {
+	return c != nil && iteration%leakCheckEvery == 0
+}
+
+// track sets a finalizer on root, the root of the tree of depth built by
+// iteration.
+func (c *leakChecker) track(root any, depth, iteration int) {
+	s := &leakSample{root: LeakedRoot{Depth: depth, Iteration: iteration}}
+	runtime.SetFinalizer(root, func(any) { s.collected.Store(true) })
+	c.mu.Lock()
+	c.samples = append(c.samples, s)
+	c.mu.Unlock()
+}
+
+// check collects garbage until every sampled root has been finalized, or
+// for leakCheckGCs collections, and returns the roots that were not.
+func (c *leakChecker) check() *LeakStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	survivors := func() []LeakedRoot {
+		var roots []LeakedRoot
+		for _, s := range c.samples {
+			if !s.collected.Load() {
+				roots = append(roots, s.root)
+			}
+		}
+		return roots
+	}
+	for i := 0; i < leakCheckGCs && len(survivors()) > 0; i++ {
+		runtime.GC()
+		// Finalizers run on their own goroutine after the collection.
+		time.Sleep(10 * time.Millisecond)
+	}
+	return &LeakStats{Sampled: len(c.samples), Survivors: survivors()}
+}
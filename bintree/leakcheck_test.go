@@ -0,0 +1,42 @@
+package bintree
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestLeakChecker(t *testing.T) {
+	c := new(leakChecker)
+	kept := NewTree[struct{}](4, nil)
+	c.track(kept, 4, 0)
+	c.track(NewTree[struct{}](4, nil), 4, leakCheckEvery)
+
+	stats := c.check()
+	if stats.Sampled != 2 {
+		t.Errorf("sampled %d roots, want 2", stats.Sampled)
+	}
+	want := LeakedRoot{Depth: 4, Iteration: 0}
+	if len(stats.Survivors) != 1 || stats.Survivors[0] != want {
+		t.Errorf("survivors = %v, want %v", stats.Survivors, []LeakedRoot{want})
+	}
+	runtime.KeepAlive(kept)
+}
+
+func TestRunLeakCheck(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.Alloc = "heap"
+	cfg.LeakCheck = true
+	cfg.Quiet = true
+	res, err := RunResults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaks := res.GC.Leaks
+	if leaks == nil || leaks.Sampled == 0 {
+		t.Fatalf("leak stats = %+v, want sampled roots", leaks)
+	}
+	if len(leaks.Survivors) > 0 {
+		t.Errorf("the run retained %v", leaks.Survivors)
+	}
+}
@@ -0,0 +1,110 @@
+package bintree
+
+import (
+	"fmt"
+	"io"
+	"runtime/metrics"
+	"sort"
+	"strings"
+)
+
+// memoryClassPrefix is the prefix of the runtime/metrics names breaking down
+// the memory mapped by the runtime.
+const memoryClassPrefix = "/memory/classes/"
+
+// memoryClassNames are the runtime/metrics names sampled for the memory
+// class breakdown: every /memory/classes/ metric, and any other byte count
+// the toolchain has for arenas.
+var memoryClassNames = func() []string {
+	var names []string
+	for _, d := range metrics.All() {
+		if d.Kind != metrics.KindUint64 {
+			continue
+		}
+		if strings.HasPrefix(d.Name, memoryClassPrefix) || strings.Contains(d.Name, "arena") {
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}()
+
+// maxMemoryClasses is the number of memory classes the text output shows,
+// those that changed the most, besides the total.
+const maxMemoryClasses = 6
+
+// MemoryClass is the change in one runtime/metrics memory class during a run.
+type MemoryClass struct {
+	// Name is the metric name without the /memory/classes/ prefix and the
+	// :bytes unit.
+	Name   string `json:"name"`
+	Before uint64 `json:"before_bytes"`
+	After  uint64 `json:"after_bytes"`
+}
+
+// Delta returns the change in bytes.
+func (c MemoryClass) Delta() int64 {
+	return int64(c.After) - int64(c.Before)
+}
+
+// newMemoryClassSamples returns the samples to read the memory classes
+// into. Reading into them with metrics.Read does not allocate.
+func newMemoryClassSamples() []metrics.Sample {
+	samples := make([]metrics.Sample, len(memoryClassNames))
+	for i, name := range memoryClassNames {
+		samples[i].Name = name
+	}
+	return samples
+}
+
+// memoryClasses pairs up the samples read at the start and end of a run.
+func memoryClasses(before, after []metrics.Sample) []MemoryClass {
+	classes := make([]MemoryClass, 0, len(before))
+	for i := range before {
+		if before[i].Value.Kind() != metrics.KindUint64 {
+			continue
+		}
+		name := strings.TrimPrefix(before[i].Name, memoryClassPrefix)
+		name = strings.TrimSuffix(name, ":bytes")
+		classes = append(classes, MemoryClass{
+			Name:   name,
+			Before: before[i].Value.Uint64(),
+			After:  after[i].Value.Uint64(),
+		})
+	}
+	return classes
+}
+
+// printMemoryClasses writes the memory classes that changed the most during
+// the run as a text table, followed by the total.
+func printMemoryClasses(w io.Writer, label string, classes []MemoryClass) {
+	var total *MemoryClass
+	var top []MemoryClass
+	for i, c := range classes {
+		switch {
+		case c.Name == "total":
+			total = &classes[i]
+		case c.Delta() != 0:
+			top = append(top, c)
+		}
+	}
+	abs := func(d int64) int64 {
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.SliceStable(top, func(i, j int) bool { return abs(top[i].Delta()) > abs(top[j].Delta()) })
+	if len(top) > maxMemoryClasses {
+		top = top[:maxMemoryClasses]
+	}
+	if total != nil {
+		top = append(top, *total)
+	}
+
+	mb := func(b uint64) float64 { return float64(b) / (1 << 20) }
+	fmt.Fprintf(w, "%s%-28s %10s %10s %10s\n", label, "memory class", "before MB", "after MB", "delta MB")
+	for _, c := range top {
+		fmt.Fprintf(w, "%s%-28s %10.1f %10.1f %+10.1f\n",
+			label, c.Name, mb(c.Before), mb(c.After), float64(c.Delta())/(1<<20))
+	}
+}
@@ -0,0 +1,24 @@
+package bintree
+
+import (
+	"runtime/metrics"
+	"testing"
+)
+
+func TestMemoryClasses(t *testing.T) {
+	before, after := newMemoryClassSamples(), newMemoryClassSamples()
+	metrics.Read(before)
+	if allocs := testing.AllocsPerRun(10, func() { metrics.Read(after) }); allocs != 0 {
+		t.Errorf("reading the memory classes allocated %v times", allocs)
+	}
+
+	names := make(map[string]bool)
+	for _, c := range memoryClasses(before, after) {
+		names[c.Name] = true
+	}
+	for _, name := range []string{"heap/objects", "heap/unused", "heap/released", "os-stacks", "other", "total"} {
+		if !names[name] {
+			t.Errorf("no %s memory class in %v", name, names)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package bintree
+
+import (
+	"arena"
+	"fmt"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// Micros lists the supported microbenchmarks, which measure one operation
+// in isolation instead of running the tree workload.
+var Micros = []string{"newarena", "alloc"}
+
+// MicroResult is the timing of one operation of a microbenchmark.
+type MicroResult struct {
+	Name    string        `json:"name"`
+	Ops     int           `json:"ops"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// NsPerOp returns the average time of an operation in nanoseconds.
+func (r MicroResult) NsPerOp() float64 {
+	if r.Ops == 0 {
+		return 0
+	}
+	return float64(r.Elapsed) / float64(r.Ops)
+}
+
+// PrintMicro writes the results of a microbenchmark as a text table, with
+// the time of each operation relative to the fastest.
+func PrintMicro(w io.Writer, results []MicroResult) {
+	fastest := 0.0
+	for _, r := range results {
+		if ns := r.NsPerOp(); ns > 0 && (fastest == 0 || ns < fastest) {
+			fastest = ns
+		}
+	}
+	fmt.Fprintf(w, "%-28s %10s %12s %12s\n", "operation", "ops", "ns/op", "vs fastest")
+	for _, r := range results {
+		var rel float64
+		if fastest > 0 {
+			rel = r.NsPerOp() / fastest
+		}
+		fmt.Fprintf(w, "%-28s %10d %12.1f %11.2fx\n", r.Name, r.Ops, r.NsPerOp(), rel)
+	}
+}
+
+// newArenaBatch is the number of arenas MicroNewArena creates before
+// freeing them, so that creating them can be timed without a clock read per
+// arena, while holding few enough that the memory they reserve stays small.
+const newArenaBatch = 16
+
+// MicroNewArena creates and frees n arenas in the calling goroutine, and
+// returns the time taken to create an arena, to create and free one, and to
+// create one, allocate a single int64 from it, which maps its first chunk,
+// and free it.
+func MicroNewArena(n int) []MicroResult {
+	create := MicroResult{Name: "arena.NewArena"}
+	var batch [newArenaBatch]*arena.Arena
+	for create.Ops < n {
+		k := n - create.Ops
+		if k > len(batch) {
+			k = len(batch)
+		}
+		start := time.Now()
+		for i := 0; i < k; i++ {
+			batch[i] = arena.NewArena()
+		}
+		create.Elapsed += time.Since(start)
+		for i := 0; i < k; i++ {
+			batch[i].Free()
+			batch[i] = nil
+		}
+		create.Ops += k
+	}
+
+	createFree := MicroResult{Name: "arena.NewArena+Free", Ops: n}
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		arena.NewArena().Free()
+	}
+	createFree.Elapsed = time.Since(start)
+
+	createAllocFree := MicroResult{Name: "arena.NewArena+New+Free", Ops: n}
+	start = time.Now()
+	for i := 0; i < n; i++ {
+		a := arena.NewArena()
+		*arena.New[int64](a) = int64(i)
+		a.Free()
+	}
+	createAllocFree.Elapsed = time.Since(start)
+
+	return []MicroResult{create, createFree, createAllocFree}
+}
+
+// MicroAlloc allocates n tree nodes of the payload cfg configures, one at a
+// time, with the cfg.Alloc allocator in the calling goroutine, and returns
+// the time taken per node. It recycles the allocator every cfg.MinAllocMB,
+// which is not timed, nor is returning the nodes to a recycling allocator.
+func MicroAlloc(cfg Config, n int) MicroResult {
+	switch {
+	case cfg.Padding == 64:
+		return microAlloc[[64]byte](&cfg, n)
+	case cfg.Padding == 256:
+		return microAlloc[[256]byte](&cfg, n)
+	case cfg.Padding == 1024:
+		return microAlloc[[1024]byte](&cfg, n)
+	}
+	switch cfg.Payload {
+	case "int64":
+		return microAlloc[int64](&cfg, n)
+	case "[64]byte":
+		return microAlloc[[64]byte](&cfg, n)
+	case "string":
+		return microAlloc[string](&cfg, n)
+	case "*int64":
+		return microAlloc[*int64](&cfg, n)
+	case "parent":
+		return microAlloc[parentNode](&cfg, n)
+	default:
+		return microAlloc[struct{}](&cfg, n)
+	}
+}
+
+func microAlloc[T any](cfg *Config, n int) MicroResult {
+	a := newAllocatorFunc[T](cfg)()
+	defer a.Free()
+	releaser, _ := a.(TreeReleaser[T])
+
+	// The nodes allocated since the last reset are kept in nodes, so none
+	// are unreachable while they are allocated.
+	perReset := cfg.minAllocBytes() / int(unsafe.Sizeof(Tree[T]{}))
+	if perReset < 1 {
+		perReset = 1
+	}
+	if perReset > n {
+		perReset = n
+	}
+	nodes := make([]*Tree[T], perReset)
+
+	res := MicroResult{Name: cfg.Alloc}
+	for res.Ops < n {
+		k := n - res.Ops
+		if k > len(nodes) {
+			k = len(nodes)
+		}
+		start := time.Now()
+		for i := 0; i < k; i++ {
+			nodes[i] = a.NewTreeNode()
+		}
+		res.Elapsed += time.Since(start)
+		res.Ops += k
+
+		for i := 0; i < k; i++ {
+			if releaser != nil {
+				releaser.ReleaseTree(nodes[i])
+			}
+			nodes[i] = nil
+		}
+		a.Reset()
+	}
+	return res
+}
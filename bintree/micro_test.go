@@ -0,0 +1,28 @@
+package bintree
+
+import "testing"
+
+func TestMicroNewArena(t *testing.T) {
+	results := MicroNewArena(40)
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for _, r := range results {
+		if r.Ops != 40 || r.Elapsed <= 0 {
+			t.Errorf("%s: %d ops in %v, want 40 ops", r.Name, r.Ops, r.Elapsed)
+		}
+	}
+}
+
+func TestMicroAlloc(t *testing.T) {
+	for _, name := range AllocatorNames() {
+		cfg := DefaultConfig()
+		cfg.Alloc = name
+		// Recycle the allocator a few times in the run.
+		cfg.MinAllocMB = 1.0 / 1024
+		r := MicroAlloc(cfg, 1000)
+		if r.Name != name || r.Ops != 1000 || r.Elapsed <= 0 {
+			t.Errorf("%s: got %q with %d ops in %v, want 1000 ops", name, r.Name, r.Ops, r.Elapsed)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package bintree
+
+import "fmt"
+
+// MutateStats counts the work of the mutate workload at a depth.
+type MutateStats struct {
+	// Replaced is the number of subtrees replaced, and Rebuilds the number
+	// of whole trees built, once at the start of the depth and once after
+	// every reset of the allocator.
+	Replaced int `json:"replaced"`
+	Rebuilds int `json:"rebuilds"`
+
+	// Allocated is the number of nodes allocated for the trees, and
+	// Garbage the number of those no longer reachable from the tree they
+	// were allocated for when it was dropped, either on a reset or at the
+	// end of the depth.
+	Allocated int `json:"allocated_nodes"`
+	Garbage   int `json:"garbage_nodes"`
+}
+
+// GarbageRatio returns the fraction of the allocated nodes that were
+// garbage, or 0 if none were allocated.
+func (s MutateStats) GarbageRatio() float64 {
+	if s.Allocated == 0 {
+		return 0
+	}
+	return float64(s.Garbage) / float64(s.Allocated)
+}
+
+// Growth returns how many times the nodes of a live tree its arena
+// allocated on average before the tree was dropped, or 0 if none were
+// allocated.
+func (s MutateStats) Growth() float64 {
+	if s.Allocated == s.Garbage {
+		return 0
+	}
+	return float64(s.Allocated) / float64(s.Allocated-s.Garbage)
+}
+
+// String formats s for a line of text output.
+func (s MutateStats) String() string {
+	return fmt.Sprintf("replaced: %d rebuilds: %d garbage: %0.1f%% growth: %0.1fx",
+		s.Replaced, s.Rebuilds, s.GarbageRatio()*100, s.Growth())
+}
+
+// mutator is the state of the mutate workload of a worker: the live tree,
+// which is dropped when the allocator is reset, and its statistics.
+type mutator[T any] struct {
+	tree  *Tree[T]
+	live  int // nodes of tree
+	gen   int // nodes allocated for tree
+	stats MutateStats
+}
+
+// drop drops the live tree, counting the nodes allocated for it that it no
+// longer reaches as garbage.
+func (m *mutator[T]) drop() {
+	if m.tree == nil {
+		return
+	}
+	m.stats.Allocated += m.gen
+	m.stats.Garbage += m.gen - m.live
+	m.tree, m.live, m.gen = nil, 0, 0
+}
+
+// mutateLevel returns the level below the root of the subtrees replaced by
+// the mutate workload in a tree of depth.
+func (cfg *Config) mutateLevel(depth int) int {
+	level := cfg.MutateLevel
+	if level == 0 {
+		level = (depth + 1) / 2
+	}
+	if level > depth {
+		level = depth
+	}
+	return level
+}
+
+// mutateTrees keeps a complete tree of depth alive, building it if the
+// worker has none, and otherwise replaces the subtree at a pseudo-random
+// path of cfg.MutateLevel levels below its root with a freshly built one.
+// The replaced nodes are left in the allocator, as an arena cannot free
+// them; the whole tree is rebuilt once the allocator is reset.
+func mutateTrees[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	m := &w.mutate
+	if m.tree == nil {
+		m.tree = w.r.build(depth, w.alloc)
+		nodes = m.tree.Count()
+		if w.r.badCount(depth, nodes) {
+			w.countErrors++
+		}
+		m.live, m.gen = nodes, nodes
+		m.stats.Rebuilds++
+		return nodes, nodes * w.r.nodeSize
+	}
+
+	level := w.r.cfg.mutateLevel(depth)
+	sub := w.r.build(depth-level, w.alloc)
+	nodes = sub.Count()
+	if w.r.badCount(depth-level, nodes) {
+		w.countErrors++
+	}
+	path := w.rng.Uint64()
+	parent := m.tree
+	for i := 1; i < level; i++ {
+		if path&(1<<i) == 0 {
+			parent = parent.Left
+		} else {
+			parent = parent.Right
+		}
+	}
+	if path&1 == 0 {
+		parent.Left = sub
+	} else {
+		parent.Right = sub
+	}
+	if w.r.setParent != nil {
+		w.r.setParent(sub, parent)
+	}
+	m.gen += nodes
+	m.stats.Replaced++
+	return nodes, nodes * w.r.nodeSize
+}
@@ -0,0 +1,96 @@
+package bintree
+
+import (
+	"math"
+	"unsafe"
+)
+
+// NaryTree is a node of a tree whose nodes have any number of children, held
+// in a slice allocated along with the nodes.
+type NaryTree struct {
+	Children []*NaryTree
+}
+
+// Count returns the number of nodes of t.
+func (t *NaryTree) Count() int {
+	n := 1
+	for _, c := range t.Children {
+		n += c.Count()
+	}
+	return n
+}
+
+// arenaHolder is implemented by allocators whose current arena can also
+// hold values other than tree nodes.
+type arenaHolder interface {
+	currentArena() *countingArena
+}
+
+func (a *ArenaAllocator[T]) currentArena() *countingArena     { return a.arena }
+func (a *ArenaPoolAllocator[T]) currentArena() *countingArena { return a.arena }
+
+// naryDepth returns the depth of the complete trees with branch children per
+// node whose node count is the closest, on a log scale, to that of a complete
+// binary tree of depth.
+func naryDepth(depth, branch int) int {
+	d := int(math.Round(float64(depth+1)/math.Log2(float64(branch)))) - 1
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// naryNodes returns the number of nodes of a complete tree of depth with
+// branch children per node.
+func naryNodes(depth, branch int) int {
+	nodes, level := 0, 1
+	for i := 0; i <= depth; i++ {
+		nodes += level
+		level *= branch
+	}
+	return nodes
+}
+
+// newNaryTree builds a complete tree of depth with branch children per node,
+// allocating the nodes and their child slices from c, or from the regular
+// heap if c is nil.
+func newNaryTree(c *countingArena, depth, branch int) *NaryTree {
+	var t *NaryTree
+	if c != nil {
+		t = arenaNew[NaryTree](c)
+	} else {
+		t = new(NaryTree)
+	}
+	if depth == 0 {
+		return t
+	}
+	if c != nil {
+		t.Children = arenaMakeSlice[*NaryTree](c, branch, branch)
+	} else {
+		t.Children = make([]*NaryTree, branch)
+	}
+	for i := range t.Children {
+		t.Children[i] = newNaryTree(c, depth-1, branch)
+	}
+	return t
+}
+
+// naryTrees builds and counts a complete tree with cfg.Branch children per
+// node, of the depth given by naryDepth, from the worker's arena or from the
+// regular heap if its allocator has none. The bytes include the child
+// slices.
+func naryTrees[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	var c *countingArena
+	if h, ok := w.alloc.(arenaHolder); ok {
+		c = h.currentArena()
+	}
+	branch := w.r.cfg.Branch
+	d := naryDepth(depth, branch)
+	tree := newNaryTree(c, d, branch)
+	nodes = tree.Count()
+	if !w.r.cfg.NoValidate && nodes != naryNodes(d, branch) {
+		w.countErrors++
+	}
+	// Every node but the root is in the child slice of its parent.
+	return nodes, nodes*int(unsafe.Sizeof(NaryTree{})) + (nodes-1)*int(unsafe.Sizeof((*NaryTree)(nil)))
+}
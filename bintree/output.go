@@ -0,0 +1,704 @@
+package bintree
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats lists the supported output formats. The bench format is the go
+// test benchmark format, for benchstat, and the compat format is exactly the
+// output of the Benchmarks Game reference program.
+var Formats = []string{"text", "json", "csv", "bench", "compat"}
+
+// Kinds of result lines.
+const (
+	KindStretch   = "stretch"
+	KindDepth     = "depth"
+	KindLongLived = "longlived"
+)
+
+// Result holds the statistics for one line of output.
+type Result struct {
+	Kind       string        `json:"-"`
+	Iterations int           `json:"iterations"`
+	Depth      int           `json:"depth"`
+	Arenas     int           `json:"arenas"`
+	Nodes      int           `json:"nodes"`
+	Bytes      int           `json:"bytes"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	Alloc      *AllocStats   `json:"alloc,omitempty"`
+
+	// CountElapsed is the time taken to Count the trees. The long-lived
+	// tree is counted after the other trees are done, so its CountElapsed
+	// is not part of Elapsed; for the other trees it is the traversal part
+	// of Elapsed, the rest being the build. It is only measured for
+	// complete trees.
+	CountElapsed time.Duration `json:"count_ns,omitempty"`
+
+	// CloneElapsed and CloneBytes describe the deep copy of the long-lived
+	// tree out of its arena with -clonelonglived.
+	CloneElapsed time.Duration `json:"clone_ns,omitempty"`
+	CloneBytes   int           `json:"clone_bytes,omitempty"`
+
+	// FreeElapsed is the time spent in arena.Free while building the trees,
+	// which is part of Elapsed. The arena freed after the last tree is not
+	// included.
+	FreeElapsed time.Duration `json:"free_ns,omitempty"`
+
+	// CountErrors is the number of complete trees that had the wrong node
+	// count, if they were validated.
+	CountErrors int `json:"count_errors,omitempty"`
+
+	// Compact describes the trees copied into survivor arenas with
+	// Config.Compact.
+	Compact *CompactStats `json:"compact,omitempty"`
+
+	// Mutate counts the work of the mutate workload.
+	Mutate *MutateStats `json:"mutate,omitempty"`
+
+	// Serialize describes the encoding of the serialize workload.
+	Serialize *SerializeStats `json:"serialize,omitempty"`
+
+	// Latency is the histogram of the time taken by each tree of a depth,
+	// including the arena reset before it, if any.
+	Latency *LatencyHistogram `json:"latency,omitempty"`
+
+	// P50, P95, P99 and MaxTree are quantiles of the time taken by each
+	// tree of a depth after the first, estimated from a sample of them.
+	P50     time.Duration `json:"p50_ns,omitempty"`
+	P95     time.Duration `json:"p95_ns,omitempty"`
+	P99     time.Duration `json:"p99_ns,omitempty"`
+	MaxTree time.Duration `json:"max_tree_ns,omitempty"`
+
+	// latencySample is the sample the quantiles were estimated from, for
+	// merging the results of a fanned out depth.
+	latencySample []time.Duration
+
+	// Partial is set if the run was canceled before all the iterations of
+	// the depth were done; Iterations is the number completed.
+	Partial bool `json:"partial,omitempty"`
+
+	// unit names what the iterations built in the text output, if not trees.
+	unit string
+
+	// timed is set if Iterations was measured over Config.BenchTime.
+	timed bool
+
+	// inserts is set if the nodes were inserted one key at a time, by the
+	// bst workload, and CountElapsed is the time taken to walk them.
+	inserts bool
+
+	// decodes is set if the nodes were decoded by the deserialize workload.
+	decodes bool
+}
+
+// NodesPerSec returns the node allocation rate of r.
+func (r Result) NodesPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Nodes) / r.Elapsed.Seconds()
+}
+
+// InsertsPerSec returns the rate at which the bst workload inserted keys,
+// leaving out the time taken to walk the trees.
+func (r Result) InsertsPerSec() float64 {
+	if r.Elapsed <= r.CountElapsed {
+		return 0
+	}
+	return float64(r.Nodes) / (r.Elapsed - r.CountElapsed).Seconds()
+}
+
+// DecodeNsPerNode returns the time the deserialize workload took to decode
+// each node, leaving out the time taken to count the trees.
+func (r Result) DecodeNsPerNode() float64 {
+	if r.Nodes == 0 {
+		return 0
+	}
+	return float64(r.Elapsed-r.CountElapsed) / float64(r.Nodes)
+}
+
+// FreePercent returns the percentage of Elapsed spent freeing arenas.
+func (r Result) FreePercent() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.FreeElapsed) / float64(r.Elapsed) * 100
+}
+
+// TreesPerSec returns the rate at which r built trees.
+func (r Result) TreesPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Iterations) / r.Elapsed.Seconds()
+}
+
+// TreesPerArena returns the average number of trees r built per arena, or 0
+// if it used none.
+func (r Result) TreesPerArena() float64 {
+	if r.Arenas == 0 {
+		return 0
+	}
+	return float64(r.Iterations) / float64(r.Arenas)
+}
+
+// HeapPercent returns the percentage of the nodes of r allocated on the heap
+// instead of the arena, or 0 if it allocated none.
+func (r Result) HeapPercent() float64 {
+	if r.Alloc == nil || r.Nodes == 0 {
+		return 0
+	}
+	return float64(r.Alloc.Heap) / float64(r.Nodes) * 100
+}
+
+// String formats r as a line of text output.
+func (r Result) String() string {
+	var prefix string
+	switch r.Kind {
+	case KindStretch:
+		prefix = "   stretch tree"
+	case KindLongLived:
+		prefix = "long lived tree"
+	default:
+		unit := r.unit
+		if unit == "" {
+			unit = "trees"
+		}
+		prefix = fmt.Sprintf(" %8d %s", r.Iterations, unit)
+	}
+	line := fmt.Sprintf("%s of depth %-8d arenas: %-6d nodes: %-10d MB: %-8.1f ms: %-9.1f nodes/sec: %.0f",
+		prefix,
+		r.Depth,
+		r.Arenas,
+		r.Nodes,
+		float64(r.Bytes)/(1<<20),
+		float64(r.Elapsed)/float64(time.Millisecond),
+		r.NodesPerSec())
+	if r.Alloc != nil && r.Alloc.Gets > 0 {
+		line += fmt.Sprintf(" pool hits: %0.1f%%", r.Alloc.HitRate()*100)
+	}
+	if r.Alloc != nil && r.Alloc.Chunks > 0 {
+		line += fmt.Sprintf(" chunks: %d", r.Alloc.Chunks)
+		if r.Arenas > 0 {
+			line += fmt.Sprintf(" chunks/arena: %0.1f", float64(r.Alloc.Chunks)/float64(r.Arenas))
+		}
+	}
+	if r.Alloc != nil && r.Alloc.Heap > 0 {
+		line += fmt.Sprintf(" heap nodes: %0.1f%%", r.HeapPercent())
+	}
+	if r.Kind == KindDepth && r.Arenas > 0 {
+		line += fmt.Sprintf(" trees/arena: %0.1f", r.TreesPerArena())
+	}
+	if r.MaxTree > 0 {
+		line += fmt.Sprintf(" p50: %v p95: %v p99: %v max: %v",
+			roundLatency(r.P50), roundLatency(r.P95), roundLatency(r.P99), roundLatency(r.MaxTree))
+	}
+	switch {
+	case r.inserts:
+		line += fmt.Sprintf(" inserts/sec: %.0f traversal ms: %0.1f",
+			r.InsertsPerSec(), float64(r.CountElapsed)/float64(time.Millisecond))
+	case r.decodes:
+		line += fmt.Sprintf(" decode ns/node: %0.1f count ms: %0.1f",
+			r.DecodeNsPerNode(), float64(r.CountElapsed)/float64(time.Millisecond))
+	case r.Kind == KindLongLived:
+		line += fmt.Sprintf(" count ms: %0.1f", float64(r.CountElapsed)/float64(time.Millisecond))
+	case r.CountElapsed > 0:
+		line += fmt.Sprintf(" build ms: %0.1f count ms: %0.1f",
+			float64(r.Elapsed-r.CountElapsed)/float64(time.Millisecond),
+			float64(r.CountElapsed)/float64(time.Millisecond))
+	}
+	if r.FreeElapsed > 0 {
+		line += fmt.Sprintf(" free ms: %0.1f (%0.2f%%)", float64(r.FreeElapsed)/float64(time.Millisecond), r.FreePercent())
+	}
+	if r.CloneBytes > 0 {
+		line += fmt.Sprintf(" clone ms: %0.1f clone MB: %0.1f",
+			float64(r.CloneElapsed)/float64(time.Millisecond),
+			float64(r.CloneBytes)/(1<<20))
+	}
+	if r.Compact != nil {
+		line += " " + r.Compact.String()
+	}
+	if r.Mutate != nil {
+		line += " " + r.Mutate.String()
+	}
+	if r.Serialize != nil {
+		line += " " + r.Serialize.String()
+	}
+	if r.timed {
+		line += fmt.Sprintf(" trees/sec: %.0f", r.TreesPerSec())
+	}
+	if r.Partial {
+		line += " (partial)"
+	}
+	if r.CountErrors > 0 {
+		line += fmt.Sprintf(" WRONG COUNT: %d trees", r.CountErrors)
+	}
+	return line
+}
+
+// TotalNodes returns the number of nodes allocated across all results.
+func TotalNodes(results []Result) int {
+	total := 0
+	for _, r := range results {
+		total += r.Nodes
+	}
+	return total
+}
+
+// Totals sums the results of a run.
+type Totals struct {
+	Trees  int `json:"trees"`
+	Arenas int `json:"arenas"`
+	Nodes  int `json:"nodes"`
+	Bytes  int `json:"bytes"`
+
+	// Chunks is the number of chunks allocated by the slab allocator for
+	// the per-depth trees, and ChunkArenas the number of arenas they were
+	// allocated from, leaving out those of the stretch and long-lived
+	// trees, which are not counted in chunks.
+	Chunks      int `json:"chunks,omitempty"`
+	ChunkArenas int `json:"chunk_arenas,omitempty"`
+
+	// HeapNodes is the number of nodes of the per-depth trees allocated on
+	// the heap instead of the arena with Config.HeapFrac.
+	HeapNodes int `json:"heap_nodes,omitempty"`
+
+	// Elapsed is the wall time of the whole run, if known.
+	Elapsed time.Duration `json:"elapsed_ns,omitempty"`
+}
+
+// String formats t as a line of text output, in the columns of the results.
+func (t Totals) String() string {
+	line := fmt.Sprintf(" %8d %-23s arenas: %-6d nodes: %-10d MB: %-8.1f wall ms: %.1f",
+		t.Trees,
+		"trees in total",
+		t.Arenas,
+		t.Nodes,
+		float64(t.Bytes)/(1<<20),
+		float64(t.Elapsed)/float64(time.Millisecond))
+	if t.Chunks > 0 && t.ChunkArenas > 0 {
+		line += fmt.Sprintf(" chunks: %d chunks/arena: %0.1f", t.Chunks, float64(t.Chunks)/float64(t.ChunkArenas))
+	}
+	if t.HeapNodes > 0 {
+		line += fmt.Sprintf(" heap nodes: %d", t.HeapNodes)
+	}
+	return line
+}
+
+// SumResults returns the totals of results across the stretch, per-depth
+// and long-lived trees.
+func SumResults(results []Result) Totals {
+	var t Totals
+	for _, r := range results {
+		t.Trees += r.Iterations
+		t.Arenas += r.Arenas
+		t.Nodes += r.Nodes
+		t.Bytes += r.Bytes
+		if r.Alloc != nil {
+			t.Chunks += r.Alloc.Chunks
+			t.ChunkArenas += r.Arenas
+			t.HeapNodes += r.Alloc.Heap
+		}
+	}
+	return t
+}
+
+// totals returns the totals of results, with the wall time of the run.
+func totals(info RunInfo, results []Result) Totals {
+	t := SumResults(results)
+	t.Elapsed = info.Elapsed
+	return t
+}
+
+// goExperiment returns the GOEXPERIMENT setting the binary was built with.
+func goExperiment() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "GOEXPERIMENT" {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+// defaultMemProfileRate is the runtime's default runtime.MemProfileRate.
+const defaultMemProfileRate = 512 * 1024
+
+// RunInfo holds the metadata describing a run.
+type RunInfo struct {
+	// GoVersion and GOExperiment describe the toolchain that built the
+	// binary; the arenas experiment must be in GOEXPERIMENT.
+	GoVersion    string `json:"go_version"`
+	GOExperiment string `json:"goexperiment"`
+	NumCPU       int    `json:"num_cpu"`
+
+	// Flags are Config.Flags, the flags set on the command line, and Env
+	// is Config.Env, the flag defaults set by the environment.
+	Flags []string `json:"flags,omitempty"`
+	Env   []string `json:"env,omitempty"`
+
+	Pass       string  `json:"pass,omitempty"`
+	Depth      int     `json:"depth"`
+	MinAllocMB float64 `json:"minalloc_mb"`
+	GOMAXPROCS int     `json:"gomaxprocs"`
+	Alloc      string  `json:"alloc"`
+	Workers    int     `json:"workers,omitempty"`
+	Fanout     int     `json:"fanout,omitempty"`
+	NodeSize   int     `json:"node_size"`
+	Payload    string  `json:"payload"`
+	Build      string  `json:"build"`
+	Layout     string  `json:"layout"`
+	Workload   string  `json:"workload"`
+
+	// LockThreads is Config.LockThreads.
+	LockThreads bool `json:"lock_threads,omitempty"`
+
+	// HeapFrac is Config.HeapFrac, if set.
+	HeapFrac float64 `json:"heap_frac,omitempty"`
+
+	// RootIndex is Config.RootIndex.
+	RootIndex bool `json:"root_index,omitempty"`
+
+	// MutateLevel is Config.MutateLevel, if set.
+	MutateLevel int `json:"mutate_level,omitempty"`
+
+	// Branch is Config.Branch with the nary workload.
+	Branch int `json:"branch,omitempty"`
+
+	FreeMode  string `json:"free_mode"`
+	FreeEvery int    `json:"free_every,omitempty"`
+	Seed      int64  `json:"seed"`
+
+	// Elapsed is the wall time of the run.
+	Elapsed time.Duration `json:"elapsed_ns"`
+
+	// BenchTime is Config.BenchTime, if set.
+	BenchTime time.Duration `json:"benchtime_ns,omitempty"`
+
+	// BallastMB and BallastType describe the live heap ballast, if any.
+	BallastMB   int    `json:"ballast_mb,omitempty"`
+	BallastType string `json:"ballast_type,omitempty"`
+
+	// Warmup and WarmupTime are Config.Warmup and Config.WarmupTime, if set.
+	Warmup     int           `json:"warmup,omitempty"`
+	WarmupTime time.Duration `json:"warmup_ns,omitempty"`
+
+	// NoValidate is Config.NoValidate.
+	NoValidate bool `json:"novalidate,omitempty"`
+
+	// MemLimit is Config.MemLimit, if set.
+	MemLimit int64 `json:"memlimit_bytes,omitempty"`
+
+	// GCPercent is the GOGC percentage during the run, -1 if the GC was
+	// disabled.
+	GCPercent int `json:"gcpercent"`
+
+	// MemProfileRate is runtime.MemProfileRate during the run, which
+	// determines how heap profiles taken during the run were sampled.
+	MemProfileRate int `json:"memprofilerate"`
+}
+
+// Results are the results of a run, as returned by RunResults. They are
+// also the JSON document written by -format=json.
+type Results struct {
+	RunInfo
+	Stretch   *Result  `json:"stretch,omitempty"`
+	Depths    []Result `json:"depths"`
+	LongLived *Result  `json:"long_lived,omitempty"`
+	Totals    Totals   `json:"totals"`
+	GC        GCStats  `json:"gc"`
+}
+
+// newResults sorts results, in output order, into Results.
+func newResults(info RunInfo, results []Result, gc GCStats) *Results {
+	res := &Results{RunInfo: info, Depths: []Result{}, Totals: totals(info, results), GC: gc}
+	for i := range results {
+		r := &results[i]
+		switch r.Kind {
+		case KindStretch:
+			res.Stretch = r
+		case KindLongLived:
+			res.LongLived = r
+		default:
+			res.Depths = append(res.Depths, *r)
+		}
+	}
+	return res
+}
+
+// All returns the results in output order: the stretch tree, the depths and
+// the long-lived tree.
+func (res *Results) All() []Result {
+	all := make([]Result, 0, len(res.Depths)+2)
+	if res.Stretch != nil {
+		all = append(all, *res.Stretch)
+	}
+	all = append(all, res.Depths...)
+	if res.LongLived != nil {
+		all = append(all, *res.LongLived)
+	}
+	return all
+}
+
+// Render writes res to w in the given output format, as Run does.
+func (res *Results) Render(w io.Writer, format string) error {
+	return PrintResults(w, format, res.RunInfo, res.All(), res.GC)
+}
+
+// RenderCSV writes res to w in the csv format, leaving out the header row
+// unless header is set, so that several runs can share one header.
+func (res *Results) RenderCSV(w io.Writer, header bool) error {
+	return printCSV(w, res.RunInfo, res.All(), header)
+}
+
+// PrintResults writes results and the GC summary to w in the given output
+// format. The first result is the stretch tree and the last the long-lived tree.
+func PrintResults(w io.Writer, format string, info RunInfo, results []Result, gc GCStats) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(newResults(info, results, gc))
+	case "csv":
+		return printCSV(w, info, results, true)
+	case "compat":
+		// The check values are the node counts, which match the reference
+		// program's for the same depth.
+		for _, r := range results {
+			var err error
+			switch r.Kind {
+			case KindStretch:
+				_, err = fmt.Fprintf(w, "stretch tree of depth %d\t check: %d\n", r.Depth, r.Nodes)
+			case KindDepth:
+				_, err = fmt.Fprintf(w, "%d\t trees of depth %d\t check: %d\n", r.Iterations, r.Depth, r.Nodes)
+			case KindLongLived:
+				_, err = fmt.Fprintf(w, "long lived tree of depth %d\t check: %d\n", r.Depth, r.Nodes)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "bench":
+		if _, err := fmt.Fprintf(w, "goos: %s\ngoarch: %s\ngo: %s\ngoexperiment: %s\nnumcpu: %d\n",
+			runtime.GOOS, runtime.GOARCH, info.GoVersion, info.GOExperiment, info.NumCPU); err != nil {
+			return err
+		}
+		name := "BenchmarkTrees"
+		if info.Pass != "" {
+			name += "/pass=" + info.Pass
+		}
+		for _, r := range results {
+			if r.Kind != KindDepth {
+				continue
+			}
+			_, err := fmt.Fprintf(w, "%s/depth=%d %d %.0f ns/op %.0f B/node %.4g arenas/op %.0f nodes/op\n",
+				name,
+				r.Depth,
+				r.Iterations,
+				float64(r.Elapsed.Nanoseconds())/float64(r.Iterations),
+				float64(r.Bytes)/float64(r.Nodes),
+				float64(r.Arenas)/float64(r.Iterations),
+				float64(r.Nodes)/float64(r.Iterations))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		var label string
+		if info.Pass != "" {
+			label = fmt.Sprintf("%-10s", info.Pass)
+		}
+		fmt.Fprintf(w, "%s%s GOEXPERIMENT=%s GOMAXPROCS: %d NumCPU: %d depth: %d minalloc: %g MB\n",
+			label, info.GoVersion, info.GOExperiment, info.GOMAXPROCS, info.NumCPU, info.Depth, info.MinAllocMB)
+		if len(info.Flags) > 0 {
+			fmt.Fprintf(w, "%sflags: %s\n", label, strings.Join(info.Flags, " "))
+		}
+		if len(info.Env) > 0 {
+			fmt.Fprintf(w, "%senv: %s\n", label, strings.Join(info.Env, " "))
+		}
+		switch {
+		case info.Workload == "mutate" && info.MutateLevel > 0:
+			fmt.Fprintf(w, "%sworkload: mutate at level %d (seed %d)\n", label, info.MutateLevel, info.Seed)
+		case info.Workload == "mutate":
+			fmt.Fprintf(w, "%sworkload: mutate at half depth (seed %d)\n", label, info.Seed)
+		case info.Workload == "nary":
+			fmt.Fprintf(w, "%sworkload: nary with %d children per node, as deep as matches a binary tree's nodes\n",
+				label, info.Branch)
+		case info.Workload != "tree":
+			fmt.Fprintf(w, "%sworkload: %s (seed %d)\n", label, info.Workload, info.Seed)
+		}
+		switch info.FreeMode {
+		case "interval":
+			fmt.Fprintf(w, "%sfree mode: every %d iterations\n", label, info.FreeEvery)
+		case "end", "never":
+			fmt.Fprintf(w, "%sfree mode: %s\n", label, info.FreeMode)
+		}
+		if info.Build != "recursive" {
+			fmt.Fprintf(w, "%sbuild: %s\n", label, info.Build)
+		}
+		if info.Layout != "postorder" {
+			fmt.Fprintf(w, "%slayout: %s\n", label, info.Layout)
+		}
+		if info.Fanout > 1 {
+			fmt.Fprintf(w, "%sfanout: %d goroutines per depth\n", label, info.Fanout)
+		}
+		if info.LockThreads {
+			fmt.Fprintf(w, "%sthreads: each depth worker locked to its OS thread\n", label)
+		}
+		if info.HeapFrac > 0 {
+			fmt.Fprintf(w, "%sheap fraction: %g of the nodes on the heap (seed %d)\n", label, info.HeapFrac, info.Seed)
+		}
+		if info.RootIndex {
+			fmt.Fprintf(w, "%sroot index: each depth worker keeps its trees' roots in a heap slice until the arena is freed\n", label)
+		}
+		// The MB column is based on the node size.
+		fmt.Fprintf(w, "%spayload: %s (node size %d bytes)\n", label, info.Payload, info.NodeSize)
+		if info.BallastMB > 0 {
+			kind := "pointer-free"
+			if info.BallastType == "pointers" {
+				kind = "pointerful"
+			}
+			fmt.Fprintf(w, "%sballast: %d MB (%s)\n", label, info.BallastMB, kind)
+		}
+		switch {
+		case info.Warmup > 0:
+			fmt.Fprintf(w, "%swarmup: %d trees per depth\n", label, info.Warmup)
+		case info.WarmupTime > 0:
+			fmt.Fprintf(w, "%swarmup: %v per depth\n", label, info.WarmupTime)
+		}
+		if info.BenchTime > 0 {
+			fmt.Fprintf(w, "%sbenchtime: %v per depth\n", label, info.BenchTime)
+		}
+		switch {
+		case info.GCPercent < 0:
+			fmt.Fprintf(w, "%sgcpercent: off\n", label)
+		case info.GCPercent != 100:
+			fmt.Fprintf(w, "%sgcpercent: %d\n", label, info.GCPercent)
+		}
+		if info.NoValidate {
+			fmt.Fprintf(w, "%snode counts: not validated\n", label)
+		}
+		if info.MemLimit > 0 {
+			fmt.Fprintf(w, "%smemlimit: %0.1f MB\n", label, float64(info.MemLimit)/(1<<20))
+		}
+		if info.MemProfileRate != defaultMemProfileRate {
+			fmt.Fprintf(w, "%smemprofilerate: %d\n", label, info.MemProfileRate)
+		}
+		for _, r := range results {
+			fmt.Fprintln(w, label+r.String())
+		}
+		fmt.Fprintln(w, label+totals(info, results).String())
+		if info.Workload == "bytes" {
+			fmt.Fprintln(w, label+gc.BytesString())
+		}
+		if gc.Arenas != nil {
+			fmt.Fprintln(w, label+gc.Arenas.String())
+		}
+		if gc.ArenaPool != nil {
+			fmt.Fprintln(w, label+gc.ArenaPool.String())
+		}
+		if info.GCPercent < 0 {
+			fmt.Fprintf(w, "%s             gc disabled   final HeapAlloc MB: %0.1f\n", label, float64(gc.FinalHeapAlloc)/(1<<20))
+		}
+		_, err := fmt.Fprintln(w, label+gc.String())
+		if err == nil && gc.Pauses != nil && gc.Pauses.total() > 0 {
+			fmt.Fprintln(w, label+"              gc pauses    "+gc.Pauses.String())
+		}
+		if err == nil && gc.Leaks != nil {
+			fmt.Fprintln(w, label+gc.Leaks.String())
+			for _, l := range gc.Leaks.Survivors {
+				fmt.Fprintln(w, label+l.String())
+			}
+		}
+		if err == nil {
+			printLatencies(w, label, results)
+		}
+		if err == nil && len(gc.Phases) > 0 {
+			fmt.Fprintln(w)
+			printPhases(w, label, gc.Phases)
+		}
+		if err == nil && len(gc.Heap) > 0 {
+			fmt.Fprintln(w)
+			printFrag(w, label, gc.Heap)
+		}
+		if err == nil && len(gc.MemoryClasses) > 0 {
+			fmt.Fprintln(w)
+			printMemoryClasses(w, label, gc.MemoryClasses)
+		}
+		return err
+	}
+}
+
+// printCSV writes results to w in the csv format, after the header row if
+// header is set.
+func printCSV(w io.Writer, info RunInfo, results []Result, header bool) error {
+	// Every row repeats the run metadata, so rows from several runs
+	// can be concatenated and still told apart.
+	meta := []string{
+		info.GoVersion,
+		info.GOExperiment,
+		strconv.Itoa(info.GOMAXPROCS),
+		strconv.Itoa(info.NumCPU),
+		strconv.Itoa(info.Depth),
+		strconv.FormatFloat(info.MinAllocMB, 'g', -1, 64),
+		strings.Join(info.Flags, " "),
+	}
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write([]string{"pass", "kind", "trees", "depth", "arenas", "nodes", "bytes", "ms",
+			"go_version", "goexperiment", "gomaxprocs", "num_cpu", "max_depth", "minalloc_mb", "flags"})
+	}
+	for _, r := range results {
+		cw.Write(append([]string{
+			info.Pass,
+			r.Kind,
+			strconv.Itoa(r.Iterations),
+			strconv.Itoa(r.Depth),
+			strconv.Itoa(r.Arenas),
+			strconv.Itoa(r.Nodes),
+			strconv.Itoa(r.Bytes),
+			strconv.FormatFloat(float64(r.Elapsed)/float64(time.Millisecond), 'f', 3, 64),
+		}, meta...))
+	}
+	t := totals(info, results)
+	cw.Write(append([]string{
+		info.Pass,
+		"total",
+		strconv.Itoa(t.Trees),
+		"",
+		strconv.Itoa(t.Arenas),
+		strconv.Itoa(t.Nodes),
+		strconv.Itoa(t.Bytes),
+		strconv.FormatFloat(float64(t.Elapsed)/float64(time.Millisecond), 'f', 3, 64),
+	}, meta...))
+	cw.Flush()
+	return cw.Error()
+}
+
+// printLatencies writes the per-tree latency histogram of each depth. Its
+// quantiles are bucket bounds, coarser than the sampled ones of Render.
+func printLatencies(w io.Writer, label string, results []Result) {
+	header := true
+	for _, r := range results {
+		if r.Latency == nil {
+			continue
+		}
+		if header {
+			fmt.Fprintln(w)
+			fmt.Fprintf(w, "%sper-tree latency histogram (quantiles are bucket bounds)\n", label)
+			header = false
+		}
+		fmt.Fprintf(w, "%s  depth %-8d %s\n", label, r.Depth, r.Latency)
+	}
+}
@@ -0,0 +1,67 @@
+package bintree
+
+import "fmt"
+
+// parentNode is the value of the nodes of the parent payload: a pointer back
+// to the node's parent, nil for the root, which makes every node three
+// pointers and gives the GC back-edges to follow.
+type parentNode struct {
+	Parent *Tree[parentNode]
+}
+
+// setParent sets the parent of t.
+func setParent(t, parent *Tree[parentNode]) { t.Value.Parent = parent }
+
+// linkParents sets the parent of every node below t.
+func linkParents(t *Tree[parentNode]) {
+	if t.Left != nil {
+		t.Left.Value.Parent = t
+		linkParents(t.Left)
+	}
+	if t.Right != nil {
+		t.Right.Value.Parent = t
+		linkParents(t.Right)
+	}
+}
+
+// parentCheckPaths are the paths from the root to the leaves whose parent
+// pointers checkParents walks back up: bit i chooses the child at level i,
+// left for 0 and right for 1.
+var parentCheckPaths = []uint64{0, ^uint64(0), 0x5555555555555555, 0xaaaaaaaaaaaaaaaa}
+
+// checkParents walks down from root to each of the leaves of
+// parentCheckPaths and back up through the parent pointers, panicking if a
+// walk does not end at root after as many steps.
+func checkParents(root *Tree[parentNode]) {
+	for _, path := range parentCheckPaths {
+		t, steps := root, 0
+		for ; t.Left != nil || t.Right != nil; steps++ {
+			if path>>(steps%64)&1 == 0 && t.Left != nil || t.Right == nil {
+				t = t.Left
+			} else {
+				t = t.Right
+			}
+		}
+		down := steps
+		for ; t.Value.Parent != nil && steps > 0; steps-- {
+			t = t.Value.Parent
+		}
+		if t != root || steps != 0 || t.Value.Parent != nil {
+			panic(fmt.Sprintf("bintree: walking up from a leaf %d levels down did not return to the root", down))
+		}
+	}
+}
+
+// withParents wraps build to link the parents of the trees it builds, and
+// check them unless validation is disabled.
+func withParents(build func(depth int, a Allocator[parentNode]) *Tree[parentNode],
+	validate bool) func(depth int, a Allocator[parentNode]) *Tree[parentNode] {
+	return func(depth int, a Allocator[parentNode]) *Tree[parentNode] {
+		t := build(depth, a)
+		linkParents(t)
+		if validate {
+			checkParents(t)
+		}
+		return t
+	}
+}
@@ -0,0 +1,44 @@
+package bintree
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestCheckParents(t *testing.T) {
+	build := withParents(NewTree[parentNode], true)
+	root := build(5, nil)
+	if root.Value.Parent != nil || root.Left.Value.Parent != root || root.Right.Left.Value.Parent != root.Right {
+		t.Fatal("parents not linked")
+	}
+
+	// Point a leaf on the rightmost path at the wrong parent.
+	leaf := root
+	for leaf.Right != nil {
+		leaf = leaf.Right
+	}
+	leaf.Value.Parent = root.Left
+	defer func() {
+		if recover() == nil {
+			t.Error("checkParents accepted a leaf with the wrong parent")
+		}
+	}()
+	checkParents(root)
+}
+
+func TestRunParentPayload(t *testing.T) {
+	for _, alloc := range []string{"arena", "heap", "prealloc"} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 10
+		cfg.Alloc = alloc
+		cfg.Payload = "parent"
+		cfg.Quiet = true
+		res, err := RunResults(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", alloc, err)
+		}
+		if want := 3 * int(unsafe.Sizeof(uintptr(0))); res.NodeSize != want {
+			t.Errorf("%s: node size %d, want %d", alloc, res.NodeSize, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package bintree
+
+import "fmt"
+
+// Payloads lists the supported Config.Payload types.
+var Payloads = []string{"none", "int64", "[64]byte", "string", "*int64", "parent"}
+
+func validPayload(payload string) bool {
+	for _, p := range Payloads {
+		if p == payload {
+			return true
+		}
+	}
+	return false
+}
+
+// payloadStrings are heap-allocated strings assigned round-robin to string
+// payloads, so the GC has real pointers to consider without allocating a new
+// string per node.
+var payloadStrings = func() []string {
+	s := make([]string, 256)
+	for i := range s {
+		s[i] = fmt.Sprintf("payload %d", i)
+	}
+	return s
+}()
+
+func fillString(v *string, i int) { *v = payloadStrings[i%len(payloadStrings)] }
+
+func fillInt64Ptr(v **int64, i int) {
+	p := new(int64)
+	*p = int64(i)
+	*v = p
+}
+
+// payloadAllocator wraps an allocator, populating the Value of every node it
+// allocates with fill.
+type payloadAllocator[T any] struct {
+	Allocator[T]
+	fill func(v *T, i int)
+	n    int
+}
+
+func (a *payloadAllocator[T]) NewTreeNode() *Tree[T] {
+	t := a.Allocator.NewTreeNode()
+	a.fill(&t.Value, a.n)
+	a.n++
+	return t
+}
+
+// BuildTree builds a tree with the wrapped allocator's TreeBuilder if it has
+// one, populating the nodes afterwards, and one node at a time otherwise.
+func (a *payloadAllocator[T]) BuildTree(depth int) *Tree[T] {
+	b, ok := a.Allocator.(TreeBuilder[T])
+	if !ok {
+		return newTree[T](depth, a)
+	}
+	t := b.BuildTree(depth)
+	a.fillTree(t)
+	return t
+}
+
+func (a *payloadAllocator[T]) fillTree(t *Tree[T]) {
+	if t == nil {
+		return
+	}
+	a.fill(&t.Value, a.n)
+	a.n++
+	a.fillTree(t.Left)
+	a.fillTree(t.Right)
+}
+
+func (a *payloadAllocator[T]) ReleaseTree(t *Tree[T]) {
+	if r, ok := a.Allocator.(TreeReleaser[T]); ok {
+		r.ReleaseTree(t)
+	}
+}
+
+func (a *payloadAllocator[T]) AllocStats() AllocStats { return allocStats(a.Allocator) }
+
+// Unwrap returns the wrapped allocator.
+func (a *payloadAllocator[T]) Unwrap() Allocator[T] { return a.Allocator }
@@ -0,0 +1,66 @@
+package bintree
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// PhaseStats holds the change in heap and stack memory during one phase of
+// a run.
+type PhaseStats struct {
+	Name      string        `json:"name"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	HeapSys   int64         `json:"heap_sys_bytes"`
+	HeapInuse int64         `json:"heap_inuse_bytes"`
+	HeapAlloc int64         `json:"heap_alloc_bytes"`
+	StackSys  int64         `json:"stack_sys_bytes"`
+}
+
+// phaseRecorder captures MemStats at the phase boundaries of a run.
+type phaseRecorder struct {
+	last   runtime.MemStats
+	start  time.Time
+	phases []PhaseStats
+}
+
+// startPhases starts recording the first phase.
+func startPhases() *phaseRecorder {
+	p := &phaseRecorder{start: time.Now()}
+	runtime.ReadMemStats(&p.last)
+	return p
+}
+
+// end ends the current phase, naming it name, and starts the next one.
+func (p *phaseRecorder) end(name string) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	now := time.Now()
+	p.phases = append(p.phases, PhaseStats{
+		Name:      name,
+		Elapsed:   now.Sub(p.start),
+		HeapSys:   int64(ms.HeapSys) - int64(p.last.HeapSys),
+		HeapInuse: int64(ms.HeapInuse) - int64(p.last.HeapInuse),
+		HeapAlloc: int64(ms.HeapAlloc) - int64(p.last.HeapAlloc),
+		StackSys:  int64(ms.StackSys) - int64(p.last.StackSys),
+	})
+	p.last = ms
+	p.start = now
+}
+
+// printPhases writes the per-phase deltas as a text table.
+func printPhases(w io.Writer, label string, phases []PhaseStats) {
+	mb := func(b int64) float64 { return float64(b) / (1 << 20) }
+	fmt.Fprintf(w, "%s%-28s %10s %12s %14s %14s %13s\n", label, "phase", "ms", "HeapSys MB", "HeapInuse MB", "HeapAlloc MB", "StackSys MB")
+	for _, p := range phases {
+		fmt.Fprintf(w, "%s%-28s %10.1f %+12.1f %+14.1f %+14.1f %+13.1f\n",
+			label,
+			p.Name,
+			float64(p.Elapsed)/float64(time.Millisecond),
+			mb(p.HeapSys),
+			mb(p.HeapInuse),
+			mb(p.HeapAlloc),
+			mb(p.StackSys))
+	}
+}
@@ -0,0 +1,160 @@
+package bintree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Progress publishes the progress of the per-depth trees of a run while it
+// is in flight. It is safe for concurrent use; set Config.Progress to have a
+// run report to it.
+type Progress struct {
+	mu      sync.Mutex
+	pass    string
+	depths  []*depthProgress
+	running bool
+
+	// Totals accumulated across runs.
+	trees, nodes, arenasCreated, arenasFreed atomic.Int64
+}
+
+// ProgressStatus is a snapshot of a Progress.
+type ProgressStatus struct {
+	Pass   string          `json:"pass,omitempty"`
+	Depths []DepthProgress `json:"depths"`
+	Totals ProgressTotals  `json:"totals"`
+}
+
+// ProgressTotals are the totals of every run reporting to a Progress,
+// including the stretch and long-lived trees.
+type ProgressTotals struct {
+	Trees         int64 `json:"trees"`
+	Nodes         int64 `json:"nodes"`
+	ArenasCreated int64 `json:"arenas_created"`
+	ArenasFreed   int64 `json:"arenas_freed"`
+}
+
+// DepthProgress is the progress of the trees of one depth. Iterations is
+// the number of trees planned, or zero with Config.BenchTime.
+type DepthProgress struct {
+	Depth      int   `json:"depth"`
+	Iterations int   `json:"iterations"`
+	Completed  int64 `json:"completed"`
+	Arenas     int64 `json:"arenas"`
+}
+
+// depthProgress holds the counters published by the worker building the
+// trees of one depth.
+type depthProgress struct {
+	depth, iterations int
+	completed, arenas atomic.Int64
+}
+
+// Totals returns the totals of every run so far.
+func (p *Progress) Totals() ProgressTotals {
+	return ProgressTotals{
+		Trees:         p.trees.Load(),
+		Nodes:         p.nodes.Load(),
+		ArenasCreated: p.arenasCreated.Load(),
+		ArenasFreed:   p.arenasFreed.Load(),
+	}
+}
+
+// Status returns a snapshot of the progress of the current run.
+func (p *Progress) Status() ProgressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status()
+}
+
+// WhileRunning calls f with a snapshot of the progress of the current run,
+// if one is in flight, and reports whether it did. The run does not write
+// its results until f returns, so f can print without interleaving with
+// them.
+func (p *Progress) WhileRunning(f func(ProgressStatus)) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return false
+	}
+	f(p.status())
+	return true
+}
+
+// status returns a snapshot of the progress; p.mu must be held.
+func (p *Progress) status() ProgressStatus {
+	s := ProgressStatus{Pass: p.pass, Depths: make([]DepthProgress, len(p.depths)), Totals: p.Totals()}
+	for i, d := range p.depths {
+		s.Depths[i] = DepthProgress{
+			Depth:      d.depth,
+			Iterations: d.iterations,
+			Completed:  d.completed.Load(),
+			Arenas:     d.arenas.Load(),
+		}
+	}
+	return s
+}
+
+// reset starts tracking a new run labelled pass.
+func (p *Progress) reset(pass string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.pass = pass
+	p.depths = nil
+	p.running = true
+	p.mu.Unlock()
+}
+
+// done records that the run has stopped building trees, before it writes its
+// results.
+func (p *Progress) done() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.running = false
+	p.mu.Unlock()
+}
+
+// addDepth starts tracking iterations trees of depth, returning the counters
+// for the worker building them to update.
+func (p *Progress) addDepth(depth, iterations int) *depthProgress {
+	if p == nil {
+		return nil
+	}
+	d := &depthProgress{depth: depth, iterations: iterations}
+	p.mu.Lock()
+	p.depths = append(p.depths, d)
+	p.mu.Unlock()
+	return d
+}
+
+// built records that trees trees with nodes nodes in all have been built.
+func (p *Progress) built(trees, nodes int) {
+	if p == nil {
+		return
+	}
+	p.trees.Add(int64(trees))
+	p.nodes.Add(int64(nodes))
+}
+
+// arenas records that created arenas have been created and freed freed.
+func (p *Progress) arenas(created, freed int) {
+	if p == nil {
+		return
+	}
+	p.arenasCreated.Add(int64(created))
+	p.arenasFreed.Add(int64(freed))
+}
+
+// add records that trees more trees have been built using arenas more
+// arenas. With Config.Fanout, several workers add to the same depth.
+func (d *depthProgress) add(trees, arenas int) {
+	if d == nil {
+		return
+	}
+	d.completed.Add(int64(trees))
+	d.arenas.Add(int64(arenas))
+}
@@ -0,0 +1,54 @@
+package bintree
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readRSS returns the resident set size of the process, from
+// /proc/self/statm. It reports false if that is not available.
+func readRSS() (uint64, bool) {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * uint64(os.Getpagesize()), true
+}
+
+// readPeakRSS returns the peak resident set size of the process, from the
+// VmHWM line of /proc/self/status. It reports false if that is not available.
+func readPeakRSS() (uint64, bool) {
+	b, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		// VmHWM:	  123456 kB
+		fields := strings.Fields(string(line))
+		if len(fields) == 3 && fields[0] == "VmHWM:" && fields[2] == "kB" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb << 10, true
+		}
+	}
+	return 0, false
+}
+
+// resetPeakRSS resets the VmHWM peak resident set size to the current
+// resident set size, so that readPeakRSS reports the peak of one run rather
+// than of the whole process.
+func resetPeakRSS() {
+	os.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+}
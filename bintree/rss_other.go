@@ -0,0 +1,30 @@
+//go:build !linux
+
+package bintree
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// readRSS would return the resident set size of the process, which is only
+// implemented on Linux.
+func readRSS() (uint64, bool) { return 0, false }
+
+// readPeakRSS returns the peak resident set size of the process, from
+// getrusage(2).
+func readPeakRSS() (uint64, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	// ru_maxrss is in bytes on macOS and in kilobytes elsewhere.
+	if runtime.GOOS == "darwin" {
+		return uint64(ru.Maxrss), true
+	}
+	return uint64(ru.Maxrss) << 10, true
+}
+
+// resetPeakRSS would reset the peak resident set size, which getrusage(2)
+// does not allow, so readPeakRSS reports the peak of the whole process.
+func resetPeakRSS() {}
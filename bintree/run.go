@@ -0,0 +1,784 @@
+package bintree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ErrCanceled is returned by Run when the run was stopped early by closing
+// Config.Cancel.
+var ErrCanceled = errors.New("bintree: run canceled")
+
+// ErrTimeout is returned by Run when the run was stopped early by
+// Config.Deadline. It wraps ErrCanceled, as the results are partial in the
+// same way.
+var ErrTimeout = fmt.Errorf("%w: deadline exceeded", ErrCanceled)
+
+// ErrCount is returned by Run, wrapped in an error naming the depths, when
+// a complete tree does not have the 2^(depth+1)-1 nodes it should, unless
+// Config.NoValidate is set.
+var ErrCount = errors.New("bintree: wrong node count")
+
+// Run the benchmark, returning the results for each output line and a
+// summary of the GC work done during the run. Unless cfg.Quiet is set, the
+// results are written to w in the cfg.Format output format.
+//
+// If cfg.Cancel is closed during the run, Run returns the results of the
+// trees completed so far, marked Partial where a depth was cut short, along
+// with ErrCanceled, or ErrTimeout if cfg.Deadline passed.
+//
+// If a tree has the wrong node count, Run prints and returns all the results
+// along with an error wrapping ErrCount.
+//
+// If a goroutine of the run panics, such as a workload whose own checks
+// failed, Run returns no results and a *PanicError once the others are done.
+func Run(cfg Config, w io.Writer) ([]Result, GCStats, error) {
+	res, err := RunResults(cfg)
+	if res == nil {
+		return nil, GCStats{}, err
+	}
+	if err != nil && !errors.Is(err, ErrCanceled) && !errors.Is(err, ErrCount) {
+		return res.All(), res.GC, err
+	}
+	if !cfg.Quiet {
+		if err := res.Render(w, cfg.Format); err != nil {
+			return res.All(), res.GC, err
+		}
+	}
+	return res.All(), res.GC, err
+}
+
+// RunResults runs the benchmark like Run, but returns the results without
+// writing them, for callers that render them with Results.Render or use
+// them otherwise. cfg.Quiet and cfg.Format are ignored.
+//
+// Results are returned along with ErrCanceled, ErrTimeout or an error
+// wrapping ErrCount just as Run returns them.
+func RunResults(cfg Config) (*Results, error) {
+	if !cfg.Deadline.IsZero() {
+		var release func()
+		cfg.Cancel, release = withDeadline(cfg.Cancel, cfg.Deadline)
+		defer release()
+	}
+	switch cfg.Workload {
+	case "random":
+		// The random workload stores its keys in the node payload.
+		r := newRunner[int64](&cfg, nil)
+		r.workload = randomTrees
+		return r.run()
+	case "list":
+		r := newRunner[int64](&cfg, nil)
+		r.workload = linkedLists
+		return r.run()
+	case "bst":
+		r := newRunner[int64](&cfg, nil)
+		r.workload = bstTrees
+		return r.run()
+	}
+	switch cfg.Padding {
+	case 64:
+		return newRunner[[64]byte](&cfg, nil).run()
+	case 256:
+		return newRunner[[256]byte](&cfg, nil).run()
+	case 1024:
+		return newRunner[[1024]byte](&cfg, nil).run()
+	}
+	switch cfg.Payload {
+	case "int64":
+		return newRunner[int64](&cfg, nil).run()
+	case "[64]byte":
+		return newRunner[[64]byte](&cfg, nil).run()
+	case "string":
+		return newRunner(&cfg, fillString).run()
+	case "*int64":
+		return newRunner(&cfg, fillInt64Ptr).run()
+	case "parent":
+		r := newRunner[parentNode](&cfg, nil)
+		r.build = withParents(r.build, !cfg.NoValidate)
+		r.setParent = setParent
+		return r.run()
+	default:
+		return newRunner[struct{}](&cfg, nil).run()
+	}
+}
+
+// runner runs the benchmark with trees carrying a T payload.
+type runner[T any] struct {
+	cfg      *Config
+	fill     func(v *T, i int)
+	newAlloc func() Allocator[T]
+	nodeSize int
+	arenas   arenaTracker
+	start    time.Time
+
+	// build builds the complete trees: the stretch and long-lived trees
+	// and those of the tree workload.
+	build func(depth int, a Allocator[T]) *Tree[T]
+
+	// newWorkerAlloc returns the allocators of the per-depth workers,
+	// which differ from newAlloc when they share an ArenaPool.
+	newWorkerAlloc func() Allocator[T]
+
+	// workload is the per-depth work done by each worker iteration.
+	workload workload[T]
+
+	// live tracks the bytes allocated and not yet released.
+	live byteGauge
+
+	// setParent, if set, links a subtree replaced by the mutate workload
+	// to its new parent, for payloads with parent pointers.
+	setParent func(t, parent *Tree[T])
+
+	// leaks tracks a sample of the per-depth trees with LeakCheck, and is
+	// nil otherwise.
+	leaks *leakChecker
+
+	// arenaLog collects the arena events of the run with Config.ArenaLog.
+	arenaLog arenaLogSink
+}
+
+// newRunner returns a runner for cfg. If fill is not nil, it populates the
+// payload of every node allocated.
+func newRunner[T any](cfg *Config, fill func(v *T, i int)) *runner[T] {
+	r := &runner[T]{
+		cfg:      cfg,
+		fill:     fill,
+		nodeSize: int(unsafe.Sizeof(Tree[T]{})),
+		build:    buildFunc[T](cfg),
+		workload: workloadFunc[T](cfg),
+	}
+	r.newAlloc = r.withFill(withHeapFrac(cfg, newAllocatorFunc[T](cfg)))
+	r.newWorkerAlloc = r.newAlloc
+	if cfg.LeakCheck && cfg.Alloc == "heap" {
+		r.leaks = new(leakChecker)
+	}
+	return r
+}
+
+// withFill wraps the allocators returned by newAlloc to populate the
+// payload of every node they allocate, if the runner has a fill func.
+func (r *runner[T]) withFill(newAlloc func() Allocator[T]) func() Allocator[T] {
+	if r.fill == nil {
+		return newAlloc
+	}
+	return func() Allocator[T] {
+		return &payloadAllocator[T]{Allocator: newAlloc(), fill: r.fill}
+	}
+}
+
+// newAllocator returns a new allocator, recording the arena it creates.
+func (r *runner[T]) newAllocator() Allocator[T] {
+	a := r.newAlloc()
+	r.arenaEvents(a.Arenas(), 0, 0)
+	return a
+}
+
+// freeAllocator frees a, recording the arena it frees.
+func (r *runner[T]) freeAllocator(a Allocator[T]) {
+	if a.Arenas() > 0 {
+		r.arenaEvents(0, 1, allocatedBytes(a, 0))
+	}
+	a.Free()
+}
+
+// arenaEvents records that created arenas have been created and freed
+// freed, after freedBytes were allocated from the freed ones, in the run's
+// arena summary and progress.
+func (r *runner[T]) arenaEvents(created, freed, freedBytes int) {
+	if freed > 0 {
+		r.arenas.free(freed, freedBytes)
+	}
+	if created > 0 {
+		r.arenas.create(created)
+	}
+	r.cfg.Progress.arenas(created, freed)
+}
+
+func (r *runner[T]) run() (*Results, error) {
+	cfg := r.cfg
+	var g group
+	r.start = time.Now()
+	cfg.Progress.reset(cfg.Label)
+
+	minDepth := cfg.MinDepth
+	maxDepth, depths := depthRange(minDepth, cfg.MaxDepth)
+
+	if cfg.MemLimit > 0 {
+		// The stretch tree alone must fit, or the run would thrash the GC
+		// for no useful comparison.
+		if stretch := int64(1<<(maxDepth+2)-1) * int64(r.nodeSize); cfg.MemLimit < stretch {
+			return nil, fmt.Errorf("memory limit of %0.1f MB is below the %0.1f MB the stretch tree of depth %d needs; "+
+				"raise the limit or lower the depth", float64(cfg.MemLimit)/(1<<20), float64(stretch)/(1<<20), maxDepth+1)
+		}
+		defer debug.SetMemoryLimit(debug.SetMemoryLimit(cfg.MemLimit))
+	}
+
+	var pool *ArenaPool
+	if cfg.ArenaPool {
+		pool = NewArenaPool(cfg.minAllocBytes())
+		pool.events = r.arenaEvents
+		r.newWorkerAlloc = r.withFill(withHeapFrac(cfg, func() Allocator[T] { return NewArenaPoolAllocator[T](pool) }))
+	}
+
+	// Allocate the ballast before anything is measured.
+	if cfg.BallastMB > 0 {
+		ballast := newBallast(cfg.BallastMB, cfg.BallastType)
+		defer runtime.KeepAlive(ballast)
+	}
+	resetPeakRSS()
+	gc := startGCStats()
+	phases := startPhases()
+	frag := startFrag()
+
+	// Create an indexed result buffer for outputing the result in order:
+	// the stretch tree, one entry per depth, and the long-lived tree.
+	outSize := len(depths) + 2
+	outBuff := make([]Result, outSize)
+
+	// abort ends a run that failed before finish, which would otherwise
+	// close the pool.
+	abort := func(err error) (*Results, error) {
+		if pool != nil {
+			pool.Close()
+		}
+		cfg.Progress.done()
+		return nil, err
+	}
+
+	if cfg.Single {
+		// thepudds: only do a single tree (with only one goroutine)
+		res, err := r.buildSingle(maxDepth + 1)
+		if err != nil {
+			return abort(err)
+		}
+		phases.end("single")
+		return r.finish(maxDepth, []Result{res}, stopped(cfg.Cancel), gc, phases, frag, pool)
+	}
+
+	// Create binary tree of depth maxDepth+1, compute its Count and set the
+	// first position of the outputBuffer with its statistics.
+	g.Go(func() {
+		if stopped(cfg.Cancel) {
+			return
+		}
+		start := time.Now()
+
+		// thepudds: create a single arena for this single (usually large) tree,
+		// freeing it when we are done with this tree.
+		stretchAlloc := r.newAllocator()
+		stretchLog := r.newArenaLog("stretch tree", maxDepth+1)
+		stretchLog.created(stretchAlloc.Arenas())
+		defer func() {
+			bytes := allocatedBytes(stretchAlloc, 0)
+			r.freeAllocator(stretchAlloc)
+			stretchLog.freed(bytes, 1, false)
+			stretchLog.flush()
+		}()
+
+		tree := r.build(maxDepth+1, stretchAlloc)
+		countStart := time.Now()
+		nodes := tree.Count()
+		countElapsed := time.Since(countStart)
+		var countErrors int
+		if r.badCount(maxDepth+1, nodes) {
+			countErrors = 1
+		}
+		bytes := allocatedBytes(stretchAlloc, nodes*r.nodeSize)
+		cfg.Progress.built(1, nodes)
+		r.live.add(bytes)
+		defer r.live.add(-bytes)
+		outBuff[0] = Result{
+			Kind:       KindStretch,
+			Iterations: 1,
+			Depth:      maxDepth + 1,
+			Arenas:     stretchAlloc.Arenas(),
+			Nodes:      nodes,
+			Bytes:      bytes,
+			Elapsed:    time.Since(start),
+
+			CountElapsed: countElapsed,
+			CountErrors:  countErrors,
+		}
+	})
+	if cfg.SerialPhases {
+		if err := g.Wait(); err != nil {
+			return abort(err)
+		}
+		phases.end("stretch")
+	}
+
+	// Create a long-lived binary tree of depth maxDepth. Its statistics will be
+	// handled later.
+	var longLivedTree *Tree[T]
+	var longLivedElapsed, cloneElapsed time.Duration
+	var longLivedBytes int
+	// cloned is set once CloneLongLived has freed the long-lived arena.
+	var cloned bool
+	// thepudds: also create a long-lived arena for this long-lived tree,
+	// freeing it when we are done with it below.
+	longLivedAlloc := r.newAllocator()
+	longLivedLog := r.newArenaLog("long-lived tree", maxDepth)
+	longLivedLog.created(longLivedAlloc.Arenas())
+	// fail frees the long-lived tree when a goroutine panicked, the others
+	// being done.
+	fail := func(err error) (*Results, error) {
+		if !cloned {
+			r.freeAllocator(longLivedAlloc)
+		}
+		return abort(err)
+	}
+
+	g.Go(func() {
+		if stopped(cfg.Cancel) {
+			return
+		}
+		start := time.Now()
+		longLivedTree = r.build(maxDepth, longLivedAlloc)
+		longLivedElapsed = time.Since(start)
+		nodes := 1<<(maxDepth+1) - 1
+		longLivedBytes = allocatedBytes(longLivedAlloc, nodes*r.nodeSize)
+		r.live.add(longLivedBytes)
+		cfg.Progress.built(1, nodes)
+
+		if cfg.CloneLongLived {
+			// Deep-copy the tree out of its arena so the arena can be freed
+			// now rather than at the end of the run.
+			start := time.Now()
+			longLivedTree = CloneTree(longLivedTree)
+			r.freeAllocator(longLivedAlloc)
+			cloned = true
+			cloneElapsed = time.Since(start)
+			longLivedLog.freed(longLivedBytes, 1, false)
+		}
+	})
+
+	if cfg.SerialPhases {
+		if err := g.Wait(); err != nil {
+			return fail(err)
+		}
+		phases.end("long-lived build")
+	}
+
+	// Create a lot of binary trees, of depths ranging from minDepth to maxDepth,
+	// compute and tally up all their Count and record the statistics.
+	var jobs chan treeJob
+	if cfg.Workers > 0 {
+		// Funnel the depths through a fixed pool of workers, each owning its
+		// own arena across the jobs it processes. The buffer holds every
+		// part of every depth, so queueing them never blocks, even if the
+		// workers have all panicked.
+		parts := 1
+		if cfg.Fanout > 1 {
+			parts = cfg.Fanout
+		}
+		jobs = make(chan treeJob, len(depths)*parts)
+		for i := 0; i < cfg.Workers; i++ {
+			g.Go(func() {
+				defer r.lockThread()()
+				tw := r.newTreeWorker()
+				defer tw.free()
+				for job := range jobs {
+					*job.out = tw.buildTrees(job.depth, job.iterations, job.progress)
+					job.done()
+				}
+			})
+		}
+	}
+	// Each depth has a result per part with Fanout, merged once they are
+	// all done.
+	parts := make([][]Result, len(depths))
+	for i, depth := range depths {
+		iterations := cfg.iterationCount(depth, minDepth, maxDepth)
+		planned := iterations
+		if cfg.BenchTime > 0 {
+			planned = 0
+		}
+		progress := cfg.Progress.addDepth(depth, planned)
+
+		split := splitIterations(iterations, cfg.Fanout)
+		parts[i] = make([]Result, len(split))
+		done := frag.depthDone(depth, len(split))
+		for j, iterations := range split {
+			out := &parts[i][j]
+			if jobs != nil {
+				jobs <- treeJob{depth: depth, iterations: iterations, out: out, progress: progress, done: done}
+				continue
+			}
+
+			depth, iterations := depth, iterations
+			g.Go(func() {
+				// Create binary trees of depth and record their statistics.
+				defer r.lockThread()()
+				tw := r.newTreeWorker()
+				defer tw.free()
+				*out = tw.buildTrees(depth, iterations, progress)
+				done()
+			})
+		}
+	}
+	if jobs != nil {
+		close(jobs)
+	}
+
+	if err := g.Wait(); err != nil {
+		return fail(err)
+	}
+	for i := range depths {
+		outBuff[i+1] = mergeResults(parts[i])
+	}
+	canceled := stopped(cfg.Cancel)
+	if cfg.SerialPhases {
+		phases.end("depths")
+	} else {
+		// The stretch tree, the long-lived tree and the depths are built
+		// concurrently, so their memory cannot be told apart.
+		phases.end("stretch+long-lived+depths")
+	}
+
+	// Compute the checksum of the long-lived binary tree that we created
+	// earlier and store its statistics, unless the run was canceled before
+	// it was built.
+	if longLivedTree != nil {
+		countStart := time.Now()
+		nodes := longLivedTree.Count()
+		outBuff[outSize-1] = Result{
+			Kind:       KindLongLived,
+			Iterations: 1,
+			Depth:      maxDepth,
+			Arenas:     longLivedAlloc.Arenas(),
+			Nodes:      nodes,
+			Bytes:      longLivedBytes,
+			Elapsed:    longLivedElapsed,
+
+			CountElapsed: time.Since(countStart),
+		}
+		if r.badCount(maxDepth, nodes) {
+			outBuff[outSize-1].CountErrors = 1
+		}
+		if cfg.CloneLongLived {
+			outBuff[outSize-1].CloneElapsed = cloneElapsed
+			outBuff[outSize-1].CloneBytes = nodes * r.nodeSize
+		}
+	}
+	phases.end("long-lived count")
+	if !cloned {
+		r.freeAllocator(longLivedAlloc)
+		longLivedLog.freed(longLivedBytes, 1, false)
+	}
+	longLivedLog.flush()
+	longLivedTree = nil
+	phases.end("long-lived free")
+	return r.finish(maxDepth, outBuff, canceled, gc, phases, frag, pool)
+}
+
+// lockThread locks the calling worker goroutine to its OS thread with
+// cfg.LockThreads, returning the function that unlocks it.
+func (r *runner[T]) lockThread() (unlock func()) {
+	if !r.cfg.LockThreads {
+		return func() {}
+	}
+	runtime.LockOSThread()
+	return runtime.UnlockOSThread
+}
+
+// buildSingle builds cfg.SingleIters complete trees of cfg.SingleDepth, or
+// of stretchDepth if it is not set, in the calling goroutine, recycling the
+// arena as the per-depth workers do. With cfg.Fanout the trees are split
+// across that many goroutines instead, as the trees of a depth are. The
+// result is a stretch tree if that is the only tree. It returns a
+// *PanicError if building panicked.
+func (r *runner[T]) buildSingle(stretchDepth int) (Result, error) {
+	depth := r.cfg.SingleDepth
+	if depth == 0 {
+		depth = stretchDepth
+	}
+	iterations := r.cfg.SingleIters
+	if iterations < 1 {
+		iterations = 1
+	}
+
+	// Whatever the workload, single mode builds complete trees.
+	r.workload = completeTrees[T]
+	progress := r.cfg.Progress.addDepth(depth, iterations)
+	split := splitIterations(iterations, r.cfg.Fanout)
+	parts := make([]Result, len(split))
+	var g group
+	for i, iterations := range split {
+		out := &parts[i]
+		iterations := iterations
+		build := func() {
+			defer r.lockThread()()
+			w := r.newTreeWorker()
+			defer w.free()
+			*out = w.buildTrees(depth, iterations, progress)
+		}
+		if len(split) == 1 {
+			g.run(build)
+			break
+		}
+		g.Go(build)
+	}
+	if err := g.Wait(); err != nil {
+		return Result{}, err
+	}
+	res := mergeResults(parts)
+	if res.Kind != "" && depth == stretchDepth && res.Iterations == 1 {
+		res.Kind = KindStretch
+	}
+	return res, nil
+}
+
+// depthRange returns maxDepth, raised to minDepth+2 if it is lower, and the
+// depths of the per-depth trees: minDepth to maxDepth in steps of 2. If
+// maxDepth-minDepth is odd, the last of them is maxDepth-1.
+func depthRange(minDepth, maxDepth int) (int, []int) {
+	if maxDepth < minDepth+2 {
+		maxDepth = minDepth + 2
+	}
+	depths := make([]int, 0, (maxDepth-minDepth)/2+1)
+	for depth := minDepth; depth <= maxDepth; depth += 2 {
+		depths = append(depths, depth)
+	}
+	return maxDepth, depths
+}
+
+// checkResults returns an error describing the first result slot of an
+// uncanceled run that was never written, which would be a bug in the
+// indexing of the results.
+func checkResults(results []Result) error {
+	for i, res := range results {
+		if res.Kind == "" {
+			return fmt.Errorf("bintree: result slot %d of %d was never written", i, len(results))
+		}
+	}
+	return nil
+}
+
+// badCount reports whether a complete tree of depth counted nodes nodes
+// when it should not have, unless validation is disabled.
+func (r *runner[T]) badCount(depth, nodes int) bool {
+	return !r.cfg.NoValidate && nodes != 1<<(depth+1)-1
+}
+
+// countError returns an error wrapping ErrCount naming the results with
+// bad counts, or nil if there are none.
+func countError(results []Result) error {
+	var bad []string
+	for _, res := range results {
+		if res.CountErrors == 0 {
+			continue
+		}
+		switch res.Kind {
+		case KindStretch:
+			bad = append(bad, fmt.Sprintf("stretch tree of depth %d", res.Depth))
+		case KindLongLived:
+			bad = append(bad, fmt.Sprintf("long-lived tree of depth %d", res.Depth))
+		default:
+			bad = append(bad, fmt.Sprintf("depth %d (%d of %d trees)", res.Depth, res.CountErrors, res.Iterations))
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCount, strings.Join(bad, ", "))
+}
+
+// finish records the end of a run whose allocators have all been freed,
+// but for the arenas of pool, which it closes, and returns its Results,
+// dropping the empty results of a canceled run.
+func (r *runner[T]) finish(maxDepth int, results []Result, canceled bool,
+	gc *gcRecorder, phases *phaseRecorder, frag *fragRecorder, pool *ArenaPool) (*Results, error) {
+	cfg := r.cfg
+	if pool != nil {
+		pool.Close()
+	}
+	cfg.Progress.done()
+	if cfg.ArenaLog != nil {
+		if err := r.arenaLog.log(cfg.ArenaLog); err != nil {
+			return nil, fmt.Errorf("could not write arena log: %w", err)
+		}
+	}
+
+	// Every allocator has been freed now; see whether that returned the
+	// memory to the OS or only to the runtime.
+	rssAfterFree, _ := readRSS()
+	frag.snapshot("end")
+	if canceled {
+		// Drop the trees that were never started. Otherwise every slot
+		// must have been written; see checkResults.
+		all := results
+		results = results[:0]
+		for _, res := range all {
+			if res.Kind != "" {
+				results = append(results, res)
+			}
+		}
+	}
+
+	// Print the statistics for all of the various tree depths.
+	info := RunInfo{
+		GoVersion:    runtime.Version(),
+		GOExperiment: goExperiment(),
+		NumCPU:       runtime.NumCPU(),
+		Flags:        cfg.Flags,
+		Env:          cfg.Env,
+
+		Pass:       cfg.Label,
+		Depth:      maxDepth,
+		MinAllocMB: cfg.MinAllocMB,
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Alloc:      cfg.Alloc,
+		Workers:    cfg.Workers,
+		Fanout:     cfg.Fanout,
+		NodeSize:   r.nodeSize,
+		Payload:    cfg.payloadName(),
+		Build:      cfg.Build,
+		Layout:     cfg.Layout,
+		FreeMode:   cfg.FreeMode,
+		FreeEvery:  cfg.FreeEvery,
+		Workload:   cfg.Workload,
+		Seed:       cfg.Seed,
+		Elapsed:    time.Since(r.start),
+		BenchTime:  cfg.BenchTime,
+		BallastMB:  cfg.BallastMB,
+		Warmup:     cfg.Warmup,
+		WarmupTime: cfg.WarmupTime,
+		NoValidate: cfg.NoValidate,
+
+		MemLimit:       cfg.MemLimit,
+		GCPercent:      GCPercent(),
+		MemProfileRate: runtime.MemProfileRate,
+		LockThreads:    cfg.LockThreads,
+		HeapFrac:       cfg.HeapFrac,
+		RootIndex:      cfg.RootIndex,
+		MutateLevel:    cfg.MutateLevel,
+	}
+	if cfg.BallastMB > 0 {
+		info.BallastType = cfg.BallastType
+	}
+	if cfg.Workload == "nary" {
+		info.Branch = cfg.Branch
+	}
+	stats := gc.stop()
+	stats.PeakLive = uint64(r.live.peak.Load())
+	stats.PeakRSS, _ = readPeakRSS()
+	stats.RSSAfterFree = rssAfterFree
+	stats.Phases = phases.phases
+	stats.Heap = frag.snapshots
+	stats.Arenas = r.arenas.stats()
+	if stats.Arenas != nil {
+		stats.Arenas.wall = info.Elapsed
+	}
+	if pool != nil {
+		poolStats := pool.Stats()
+		stats.ArenaPool = &poolStats
+	}
+	if r.leaks != nil {
+		// After the statistics, which its collections would skew.
+		stats.Leaks = r.leaks.check()
+	}
+	res := newResults(info, results, stats)
+	if !canceled {
+		if err := checkResults(results); err != nil {
+			return res, err
+		}
+	}
+	if canceled {
+		if !cfg.Deadline.IsZero() && !time.Now().Before(cfg.Deadline) {
+			return res, ErrTimeout
+		}
+		return res, ErrCanceled
+	}
+	return res, countError(results)
+}
+
+// stopped reports whether c is closed. A nil c is never closed.
+func stopped(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// withDeadline returns a channel closed when cancel is closed or deadline
+// passes, whichever is first, and the function that releases its timer once
+// the run is done.
+func withDeadline(cancel <-chan struct{}, deadline time.Time) (<-chan struct{}, func()) {
+	c := make(chan struct{})
+	done := make(chan struct{})
+	t := time.NewTimer(time.Until(deadline))
+	go func() {
+		defer t.Stop()
+		select {
+		case <-cancel:
+		case <-t.C:
+		case <-done:
+			return
+		}
+		close(c)
+	}()
+	return c, func() { close(done) }
+}
+
+// PanicError is returned by Run when a goroutine of the run panicked.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("bintree: run panicked: %v\n\n%s", e.Value, e.Stack)
+}
+
+// group runs the goroutines of a benchmark run. A panic in one of them is
+// recovered and returned by Wait as a *PanicError, so that callers of Run
+// can clean up, and write profiles, as for any other error.
+type group struct {
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	panicked *PanicError
+}
+
+// Go runs f in a new goroutine.
+func (g *group) Go(f func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.run(f)
+	}()
+}
+
+// run runs f in the calling goroutine, recording its panic if it panics.
+func (g *group) run(f func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			g.mu.Lock()
+			if g.panicked == nil {
+				g.panicked = &PanicError{Value: v, Stack: debug.Stack()}
+			}
+			g.mu.Unlock()
+		}
+	}()
+	f()
+}
+
+// Wait waits for the goroutines started by Go, returning the first panic.
+func (g *group) Wait() error {
+	g.wg.Wait()
+	if g.panicked != nil {
+		return g.panicked
+	}
+	return nil
+}
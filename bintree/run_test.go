@@ -0,0 +1,810 @@
+package bintree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestRunBytes(t *testing.T) {
+	for _, payload := range []string{"none", "int64", "[64]byte"} {
+		t.Run(payload, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxDepth = 8
+			cfg.Payload = payload
+			cfg.Quiet = true
+			results, _, err := Run(cfg, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var nodeSize int
+			switch payload {
+			case "int64":
+				nodeSize = int(unsafe.Sizeof(Tree[int64]{}))
+			case "[64]byte":
+				nodeSize = int(unsafe.Sizeof(Tree[[64]byte]{}))
+			default:
+				nodeSize = int(unsafe.Sizeof(Tree[struct{}]{}))
+			}
+			for _, r := range results {
+				if want := r.Nodes * nodeSize; r.Bytes != want {
+					t.Errorf("%s depth %d: %d bytes, want %d nodes * %d bytes", r.Kind, r.Depth, r.Bytes, r.Nodes, nodeSize)
+				}
+				mb := fmt.Sprintf("MB: %-8.1f", float64(r.Nodes*nodeSize)/(1<<20))
+				if line := r.String(); !strings.Contains(line, mb) {
+					t.Errorf("%q does not report %q", line, mb)
+				}
+			}
+		})
+	}
+}
+
+func TestRunArenaStats(t *testing.T) {
+	for _, pool := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pool=%v", pool), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxDepth = 12
+			cfg.MinAllocMB = 0.1
+			cfg.ArenaPool = pool
+			cfg.Quiet = true
+			results, stats, err := Run(cfg, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := stats.Arenas
+			if s == nil {
+				t.Fatal("no arena summary")
+			}
+			if s.Freed != s.Created {
+				t.Errorf("freed %d arenas, created %d", s.Freed, s.Created)
+			}
+			if s.MaxAlive < 1 || s.MaxAlive > s.Created {
+				t.Errorf("max alive = %d, created %d", s.MaxAlive, s.Created)
+			}
+			var bytes int
+			for _, r := range results {
+				bytes += r.Bytes
+			}
+			if s.FreedBytes != int64(bytes) {
+				t.Errorf("freed arenas allocated %d bytes, results %d", s.FreedBytes, bytes)
+			}
+		})
+	}
+}
+
+func TestRunCloneLongLivedArenaStats(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.CloneLongLived = true
+	// One tree at a time, so only the long-lived arena could overlap the
+	// worker's.
+	cfg.SerialPhases = true
+	cfg.Workers = 1
+	cfg.Quiet = true
+	_, stats, err := Run(cfg, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stats.Arenas
+	if s == nil {
+		t.Fatal("no arena summary")
+	}
+	if s.Freed != s.Created {
+		t.Errorf("freed %d arenas, created %d", s.Freed, s.Created)
+	}
+	if s.MaxAlive != 1 {
+		t.Errorf("max alive = %d, want 1 with the long-lived arena freed after cloning", s.MaxAlive)
+	}
+}
+
+func TestRunFreeModes(t *testing.T) {
+	for _, tt := range []struct {
+		mode      string
+		every     int
+		arenas    int // per depth
+		leakedAll bool
+	}{
+		{mode: "end", arenas: 1},
+		{mode: "interval", every: 4, arenas: 2},
+		{mode: "never", arenas: 8, leakedAll: true},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxDepth = 10
+			cfg.Iterations = 8
+			cfg.MinAllocMB = 0
+			cfg.FreeMode = tt.mode
+			cfg.FreeEvery = tt.every
+			cfg.Quiet = true
+			results, stats, err := Run(cfg, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			created := 0
+			for _, r := range results {
+				created += r.Arenas
+				if r.Kind == KindDepth && r.Arenas != tt.arenas {
+					t.Errorf("depth %d used %d arenas, want %d", r.Depth, r.Arenas, tt.arenas)
+				}
+			}
+			s := stats.Arenas
+			if s.Created != created {
+				t.Errorf("created %d arenas, results %d", s.Created, created)
+			}
+			if s.Leaked != s.Created-s.Freed {
+				t.Errorf("leaked %d arenas, created %d and freed %d", s.Leaked, s.Created, s.Freed)
+			}
+			// Only the stretch and long-lived trees' arenas are freed
+			// in the never mode.
+			if tt.leakedAll && s.Freed != 2 || !tt.leakedAll && s.Leaked != 0 {
+				t.Errorf("freed %d arenas, leaked %d", s.Freed, s.Leaked)
+			}
+		})
+	}
+}
+
+func TestRunSingle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 8
+	cfg.Single = true
+	var out strings.Builder
+	results, _, err := Run(cfg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Kind != KindStretch || results[0].Depth != 9 {
+		t.Fatalf("results = %+v, want the stretch tree of depth 9", results)
+	}
+	if !strings.Contains(out.String(), results[0].String()) {
+		t.Errorf("output does not contain the stretch tree:\n%s", out.String())
+	}
+
+	cfg.SingleDepth = 6
+	cfg.SingleIters = 100
+	cfg.MinAllocMB = 0.01
+	cfg.Quiet = true
+	results, _, err = Run(cfg, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Kind != KindDepth || results[0].Iterations != 100 || results[0].Nodes != 100*127 {
+		t.Fatalf("results = %+v, want 100 trees of depth 6", results)
+	}
+	if results[0].Arenas < 2 {
+		t.Errorf("built 100 trees with %d arenas, want the arena recycled", results[0].Arenas)
+	}
+}
+
+func TestRunArenaLog(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 8
+	cfg.MinAllocMB = 0.01
+	cfg.Quiet = true
+	var log strings.Builder
+	cfg.ArenaLog = slog.New(slog.NewTextHandler(&log, nil))
+	results, _, err := Run(cfg, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	var created, freed int
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, `msg="arena created"`):
+			created++
+		case strings.Contains(line, `msg="arena freed"`) && strings.Contains(line, " trees="):
+			freed++
+		default:
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+	if want := SumResults(results).Arenas; created != want || freed != want {
+		t.Errorf("logged %d arenas created and %d freed, want %d of each", created, freed, want)
+	}
+	for _, owner := range []string{`msg="arena created" owner="stretch tree" depth=9`,
+		`msg="arena freed" owner="long-lived tree" depth=8`, `owner="worker 1" depth=`} {
+		if !strings.Contains(log.String(), owner) {
+			t.Errorf("log does not contain %q:\n%s", owner, log.String())
+		}
+	}
+}
+
+func TestRunSingleFanout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 8
+	cfg.Single = true
+	cfg.SingleDepth = 6
+	cfg.SingleIters = 10
+	cfg.Fanout = 3
+	cfg.Quiet = true
+	results, _, err := Run(cfg, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each of the 3 parts has its own arena.
+	if len(results) != 1 || results[0].Iterations != 10 || results[0].Nodes != 10*127 || results[0].Arenas < 3 {
+		t.Fatalf("results = %+v, want 10 trees of depth 6 from at least 3 arenas", results)
+	}
+}
+
+// shortAllocator builds trees one level short, as a buggy allocator might.
+type shortAllocator struct{ HeapAllocator[struct{}] }
+
+func (shortAllocator) BuildTree(depth int) *Tree[struct{}] {
+	return NewTree[struct{}](depth-1, nil)
+}
+
+func TestCountValidation(t *testing.T) {
+	for _, noValidate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("novalidate=%v", noValidate), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.NoValidate = noValidate
+			r := newRunner[struct{}](&cfg, nil)
+			r.newWorkerAlloc = func() Allocator[struct{}] { return shortAllocator{} }
+			w := r.newTreeWorker()
+			defer w.free()
+
+			res := w.buildTrees(4, 3, nil)
+			err := countError([]Result{res})
+			if noValidate {
+				if res.CountErrors != 0 || err != nil {
+					t.Errorf("count errors = %d, err = %v with validation disabled", res.CountErrors, err)
+				}
+				return
+			}
+			if res.CountErrors != 3 {
+				t.Errorf("count errors = %d, want 3", res.CountErrors)
+			}
+			if !errors.Is(err, ErrCount) || !strings.Contains(err.Error(), "depth 4 (3 of 3 trees)") {
+				t.Errorf("err = %v, want the depth 4 count error", err)
+			}
+		})
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 6
+	cfg.Deadline = time.Now()
+	res, err := RunResults(cfg)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrCanceled) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	if res == nil {
+		t.Fatal("no partial results")
+	}
+
+	cfg.Deadline = time.Now().Add(time.Hour)
+	if _, err := RunResults(cfg); err != nil {
+		t.Errorf("run before the deadline: %v", err)
+	}
+}
+
+func TestRunPanic(t *testing.T) {
+	for _, single := range []bool{false, true} {
+		t.Run(fmt.Sprintf("single=%v", single), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxDepth = 6
+			cfg.Single = single
+			r := newRunner[struct{}](&cfg, nil)
+			build := r.build
+			r.build = func(depth int, a Allocator[struct{}]) *Tree[struct{}] {
+				if depth == 4 || single {
+					panic("bad tree")
+				}
+				return build(depth, a)
+			}
+			res, err := r.run()
+			var perr *PanicError
+			if !errors.As(err, &perr) || perr.Value != "bad tree" || len(perr.Stack) == 0 {
+				t.Fatalf("err = %v, want the panic", err)
+			}
+			if res != nil {
+				t.Errorf("results returned with the panic")
+			}
+		})
+	}
+}
+
+func TestRunPanicWorkers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.Workers = 2
+	cfg.Fanout = 4
+	r := newRunner[struct{}](&cfg, nil)
+	build := r.build
+	r.build = func(depth int, a Allocator[struct{}]) *Tree[struct{}] {
+		if depth < cfg.MaxDepth {
+			// Every depth tree, so every pool worker panics.
+			panic("bad tree")
+		}
+		return build(depth, a)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.run()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		var perr *PanicError
+		if !errors.As(err, &perr) {
+			t.Fatalf("err = %v, want the panic", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not return after every worker panicked")
+	}
+}
+
+func TestDepthRange(t *testing.T) {
+	tests := []struct {
+		min, max int
+		wantMax  int
+		want     []int
+	}{
+		{4, 10, 10, []int{4, 6, 8, 10}},
+		// An odd difference stops short of maxDepth.
+		{4, 11, 11, []int{4, 6, 8, 10}},
+		{5, 10, 10, []int{5, 7, 9}},
+		// maxDepth is raised to minDepth+2.
+		{4, 6, 6, []int{4, 6}},
+		{4, 5, 6, []int{4, 6}},
+		{4, 0, 6, []int{4, 6}},
+	}
+	for _, tt := range tests {
+		gotMax, got := depthRange(tt.min, tt.max)
+		if gotMax != tt.wantMax || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("depthRange(%d, %d) = %d, %v, want %d, %v", tt.min, tt.max, gotMax, got, tt.wantMax, tt.want)
+		}
+	}
+}
+
+func TestRunDepths(t *testing.T) {
+	for _, tt := range []struct{ min, max int }{{4, 8}, {4, 9}, {3, 8}, {4, 5}, {6, 2}} {
+		for _, workers := range []int{0, 2} {
+			t.Run(fmt.Sprintf("%d-%d/workers=%d", tt.min, tt.max, workers), func(t *testing.T) {
+				cfg := DefaultConfig()
+				cfg.MinDepth = tt.min
+				cfg.MaxDepth = tt.max
+				cfg.Workers = workers
+				cfg.Quiet = true
+				results, _, err := Run(cfg, io.Discard)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				maxDepth, depths := depthRange(tt.min, tt.max)
+				if len(results) != len(depths)+2 {
+					t.Fatalf("got %d results, want %d", len(results), len(depths)+2)
+				}
+				if r := results[0]; r.Kind != KindStretch || r.Depth != maxDepth+1 {
+					t.Errorf("first result is %s of depth %d", r.Kind, r.Depth)
+				}
+				for i, depth := range depths {
+					if r := results[i+1]; r.Kind != KindDepth || r.Depth != depth {
+						t.Errorf("result %d is %s of depth %d, want depth %d", i+1, r.Kind, r.Depth, depth)
+					}
+				}
+				if r := results[len(results)-1]; r.Kind != KindLongLived || r.Depth != maxDepth {
+					t.Errorf("last result is %s of depth %d", r.Kind, r.Depth)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckResults(t *testing.T) {
+	results := []Result{{Kind: KindStretch}, {Kind: KindDepth}, {Kind: KindLongLived}}
+	if err := checkResults(results); err != nil {
+		t.Errorf("checkResults: %v", err)
+	}
+	results[1] = Result{}
+	if err := checkResults(results); err == nil || !strings.Contains(err.Error(), "slot 1 of 3") {
+		t.Errorf("checkResults with an empty slot = %v", err)
+	}
+}
+
+func TestRunTotals(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 8
+	var out strings.Builder
+	results, _, err := Run(cfg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want Totals
+	for _, r := range results {
+		want.Trees += r.Iterations
+		want.Nodes += r.Nodes
+	}
+	got := SumResults(results)
+	if got.Trees != want.Trees || got.Nodes != want.Nodes {
+		t.Errorf("SumResults = %+v, want %d trees and %d nodes", got, want.Trees, want.Nodes)
+	}
+	line := fmt.Sprintf(" %8d trees in total", want.Trees)
+	if !strings.Contains(out.String(), line) {
+		t.Errorf("output does not contain %q:\n%s", line, out.String())
+	}
+}
+
+func TestTotalsChunksPerArena(t *testing.T) {
+	results := []Result{
+		{Kind: KindStretch, Iterations: 1, Arenas: 1},
+		{Kind: KindDepth, Iterations: 8, Arenas: 2, Alloc: &AllocStats{Chunks: 6}},
+		{Kind: KindLongLived, Iterations: 1, Arenas: 1},
+	}
+	got := SumResults(results)
+	if got.Arenas != 4 || got.Chunks != 6 || got.ChunkArenas != 2 {
+		t.Errorf("SumResults = %+v, want 4 arenas, 6 chunks from 2 of them", got)
+	}
+	if line := got.String(); !strings.Contains(line, "chunks/arena: 3.0") {
+		t.Errorf("totals line %q does not count only the arenas of the chunks", line)
+	}
+}
+
+func TestRunResults(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 8
+	res, err := RunResults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := res.All()
+	_, depths := depthRange(cfg.MinDepth, cfg.MaxDepth)
+	if len(all) != len(depths)+2 || all[0].Kind != KindStretch || all[len(all)-1].Kind != KindLongLived {
+		t.Fatalf("All() = %+v, want the stretch tree, %d depths and the long-lived tree", all, len(depths))
+	}
+	if got, want := res.Totals.Trees, SumResults(all).Trees; got != want {
+		t.Errorf("Totals.Trees = %d, want %d", got, want)
+	}
+
+	// Rendering the same Results twice writes the same output, which is
+	// what Run writes.
+	for _, format := range Formats {
+		var a, b strings.Builder
+		if err := res.Render(&a, format); err != nil {
+			t.Fatal(err)
+		}
+		if err := PrintResults(&b, format, res.RunInfo, all, res.GC); err != nil {
+			t.Fatal(err)
+		}
+		if a.String() != b.String() || a.Len() == 0 {
+			t.Errorf("%s: Render wrote %q, PrintResults %q", format, a.String(), b.String())
+		}
+	}
+}
+
+func TestRunFreeList(t *testing.T) {
+	arenas := make(map[string]int)
+	for _, alloc := range []string{"arena", "freelist"} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 10
+		cfg.MinAllocMB = 0.1
+		cfg.Alloc = alloc
+		cfg.Quiet = true
+		results, _, err := Run(cfg, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arenas[alloc] = SumResults(results).Arenas
+	}
+	// Every tree after the first of a depth comes from the free list.
+	if arenas["freelist"] >= arenas["arena"] {
+		t.Errorf("freelist used %d arenas, arena %d", arenas["freelist"], arenas["arena"])
+	}
+}
+
+func TestRunHeapFrac(t *testing.T) {
+	for _, frac := range []float64{0.5, 1} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 10
+		cfg.HeapFrac = frac
+		cfg.Quiet = true
+		results, _, err := Run(cfg, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			if r.Kind != KindDepth {
+				continue
+			}
+			if got := r.HeapPercent() / 100; got < frac-0.05 || got > frac+0.05 {
+				t.Errorf("heap fraction %g: depth %d allocated %0.3f of its nodes on the heap", frac, r.Depth, got)
+			}
+		}
+	}
+}
+
+func TestRootIndex(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FreeMode = "interval"
+	cfg.FreeEvery = 4
+	cfg.RootIndex = true
+	r := newRunner[struct{}](&cfg, nil)
+	w := r.newTreeWorker()
+
+	w.buildTrees(4, 10, nil)
+	// The arena was freed before the fifth and ninth trees.
+	if len(w.index) != 2 {
+		t.Errorf("index holds %d roots, want 2", len(w.index))
+	}
+	index := w.index[:cap(w.index)]
+	w.free()
+	if len(w.index) != 0 {
+		t.Errorf("index holds %d roots after free, want none", len(w.index))
+	}
+	for i, root := range index {
+		if root != nil {
+			t.Fatalf("index slot %d still points at a root after free", i)
+		}
+	}
+}
+
+func TestRunHeapSnapshots(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 8
+	cfg.Fanout = 2
+	cfg.Quiet = true
+	res, err := RunResults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]int)
+	for _, s := range res.GC.Heap {
+		names[s.Name]++
+	}
+	want := map[string]int{"start": 1, "depth 4": 1, "depth 6": 1, "depth 8": 1, "end": 1}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("snapshots %v, want %v", names, want)
+	}
+	if first, last := res.GC.Heap[0].Name, res.GC.Heap[len(res.GC.Heap)-1].Name; first != "start" || last != "end" {
+		t.Errorf("snapshots run from %s to %s, want start to end", first, last)
+	}
+}
+
+func TestRunMutate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.MinAllocMB = 0.1
+	cfg.Workload = "mutate"
+	cfg.Quiet = true
+	res, err := RunResults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res.Depths {
+		m := r.Mutate
+		if m == nil {
+			t.Fatalf("depth %d has no mutate stats", r.Depth)
+		}
+		if m.Rebuilds < 1 || m.Rebuilds+m.Replaced != r.Iterations {
+			t.Errorf("depth %d: %d rebuilds and %d replacements in %d iterations", r.Depth, m.Rebuilds, m.Replaced, r.Iterations)
+		}
+		if m.Allocated != r.Nodes {
+			t.Errorf("depth %d: allocated %d nodes, built %d", r.Depth, m.Allocated, r.Nodes)
+		}
+		if ratio := m.GarbageRatio(); ratio <= 0 || ratio >= 1 {
+			t.Errorf("depth %d: garbage ratio %v", r.Depth, ratio)
+		}
+	}
+}
+
+func TestRunNary(t *testing.T) {
+	for _, alloc := range []string{"arena", "heap"} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 10
+		cfg.MinAllocMB = 0.1
+		cfg.Workload = "nary"
+		cfg.Branch = 4
+		cfg.Alloc = alloc
+		cfg.Quiet = true
+		res, err := RunResults(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", alloc, err)
+		}
+		for _, r := range res.Depths {
+			if r.CountErrors != 0 {
+				t.Errorf("%s depth %d: %d count errors", alloc, r.Depth, r.CountErrors)
+			}
+			nodes := naryNodes(naryDepth(r.Depth, 4), 4)
+			if r.Nodes != r.Iterations*nodes {
+				t.Errorf("%s depth %d: %d nodes in %d iterations, want %d per tree", alloc, r.Depth, r.Nodes, r.Iterations, nodes)
+			}
+			if r.Bytes <= r.Nodes*int(unsafe.Sizeof(NaryTree{})) {
+				t.Errorf("%s depth %d: %d bytes leave out the child slices", alloc, r.Depth, r.Bytes)
+			}
+		}
+	}
+}
+
+func TestNaryDepth(t *testing.T) {
+	for _, tt := range []struct{ depth, branch, want int }{
+		{0, 4, 0}, {9, 2, 9}, {9, 4, 4}, {10, 4, 5}, {11, 4, 5}, {11, 8, 3},
+	} {
+		if got := naryDepth(tt.depth, tt.branch); got != tt.want {
+			t.Errorf("naryDepth(%d, %d) = %d, want %d", tt.depth, tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestRunBST(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.MinAllocMB = 0.1
+	cfg.Workload = "bst"
+	cfg.BSTKeys = 500
+	cfg.Quiet = true
+	res, err := RunResults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res.Depths {
+		if r.Nodes != r.Iterations*500 {
+			t.Errorf("depth %d: %d nodes in %d iterations of 500 keys", r.Depth, r.Nodes, r.Iterations)
+		}
+		if r.CountElapsed <= 0 || r.InsertsPerSec() <= 0 {
+			t.Errorf("depth %d: walk time %v, %v inserts/sec", r.Depth, r.CountElapsed, r.InsertsPerSec())
+		}
+		if !strings.Contains(r.String(), " inserts/sec: ") {
+			t.Errorf("depth %d: %q does not report inserts/sec", r.Depth, r.String())
+		}
+	}
+}
+
+func TestWalkInOrder(t *testing.T) {
+	var root *Tree[int64]
+	for _, key := range []int64{5, 2, 8, 2, 9, 1} {
+		root = insertKey(root, key, HeapAllocator[int64]{})
+	}
+	if nodes, sorted := walkInOrder(root); nodes != 6 || !sorted {
+		t.Errorf("walkInOrder = %d, %v, want 6, true", nodes, sorted)
+	}
+	root.Left.Value = 7
+	if _, sorted := walkInOrder(root); sorted {
+		t.Error("walkInOrder found an unsorted tree sorted")
+	}
+}
+
+func TestRunSerialize(t *testing.T) {
+	for _, alloc := range []string{"arena", "heap", "pool"} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 12
+		cfg.MinAllocMB = 0.1
+		cfg.Workload = "serialize"
+		cfg.Alloc = alloc
+		cfg.Quiet = true
+		res, err := RunResults(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", alloc, err)
+		}
+		for _, r := range res.Depths {
+			s := r.Serialize
+			if s == nil {
+				t.Fatalf("%s depth %d has no serialize stats", alloc, r.Depth)
+			}
+			if s.Encoded < 2*r.Nodes || s.Buffers < s.Encoded {
+				t.Errorf("%s depth %d: %d nodes encoded in %d bytes, %d of buffers", alloc, r.Depth, r.Nodes, s.Encoded, s.Buffers)
+			}
+			if r.Bytes != r.Nodes*int(unsafe.Sizeof(Tree[struct{}]{}))+s.Buffers {
+				t.Errorf("%s depth %d: %d bytes leave out the %d bytes of buffers", alloc, r.Depth, r.Bytes, s.Buffers)
+			}
+		}
+	}
+}
+
+func TestRunDeserialize(t *testing.T) {
+	for _, alloc := range []string{"arena", "heap", "freelist"} {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 12
+		cfg.MinAllocMB = 0.1
+		cfg.Workload = "deserialize"
+		cfg.Alloc = alloc
+		cfg.Quiet = true
+		res, err := RunResults(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", alloc, err)
+		}
+		for _, r := range res.Depths {
+			if r.CountErrors != 0 {
+				t.Errorf("%s depth %d: %d count errors", alloc, r.Depth, r.CountErrors)
+			}
+			if r.Nodes != r.Iterations*(1<<(r.Depth+1)-1) {
+				t.Errorf("%s depth %d: %d nodes in %d iterations", alloc, r.Depth, r.Nodes, r.Iterations)
+			}
+			if r.DecodeNsPerNode() <= 0 || !strings.Contains(r.String(), " decode ns/node: ") {
+				t.Errorf("%s depth %d: %q does not report the decode time", alloc, r.Depth, r.String())
+			}
+		}
+	}
+}
+
+func TestDecodeTree(t *testing.T) {
+	buf := encodeTree[int64](5)
+	d := treeDecoder[int64]{alloc: HeapAllocator[int64]{}, buf: buf}
+	if n := d.decode().Count(); n != 63 || len(d.buf) != 0 {
+		t.Errorf("decoded %d nodes with %d bytes left, want 63 and none", n, len(d.buf))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("decoding a truncated tree did not panic")
+		}
+	}()
+	d = treeDecoder[int64]{alloc: HeapAllocator[int64]{}, buf: buf[:len(buf)/2]}
+	d.decode()
+}
+
+func TestRenderCompat(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.Format = "compat"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := RunResults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := res.Render(&b, cfg.Format); err != nil {
+		t.Fatal(err)
+	}
+	// The output of the reference program for depth 10.
+	want := "stretch tree of depth 11\t check: 4095\n" +
+		"1024\t trees of depth 4\t check: 31744\n" +
+		"256\t trees of depth 6\t check: 32512\n" +
+		"64\t trees of depth 8\t check: 32704\n" +
+		"16\t trees of depth 10\t check: 32752\n" +
+		"long lived tree of depth 10\t check: 2047\n"
+	if b.String() != want {
+		t.Errorf("compat output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestConfigNodeSize(t *testing.T) {
+	for _, payload := range Payloads {
+		cfg := DefaultConfig()
+		cfg.MaxDepth = 6
+		cfg.Payload = payload
+		cfg.Quiet = true
+		res, err := RunResults(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", payload, err)
+		}
+		if got := cfg.NodeSize(); got != res.RunInfo.NodeSize {
+			t.Errorf("%s: NodeSize = %d, the run used %d", payload, got, res.RunInfo.NodeSize)
+		}
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDepth = 10
+	cfg.MinAllocMB = 0
+	// The stretch and long-lived trees, and depths 4 to 10 with a worker
+	// each.
+	want := int64(4095+2047+31+127+511+2047) * 16
+	e := cfg.EstimateMemory()
+	if e.Total() != want || e.Workers != 4 {
+		t.Errorf("EstimateMemory = %+v, want %d bytes for 4 workers", e, want)
+	}
+	if got := e.Largest(); got != "stretch tree of depth 11" {
+		t.Errorf("Largest = %q", got)
+	}
+	if got := cfg.FitDepth(e.Total()); got != 10 {
+		t.Errorf("FitDepth of the estimate = %d, want 10", got)
+	}
+	if got := cfg.FitDepth(e.Total() - 1); got != 9 {
+		t.Errorf("FitDepth below the estimate = %d, want 9", got)
+	}
+}
@@ -0,0 +1,168 @@
+package bintree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// serializeBufSize is the size of the first buffer the serialize workload
+// encodes each tree into, before growing it.
+const serializeBufSize = 64
+
+// SerializeStats describes the encoding of the trees of the serialize
+// workload at a depth.
+type SerializeStats struct {
+	// Encoded is the size of the encodings, and Buffers that of all the
+	// buffers allocated for them, including those outgrown.
+	Encoded int `json:"encoded_bytes"`
+	Buffers int `json:"buffer_bytes"`
+
+	// Elapsed is the time taken to encode the trees, leaving out building
+	// them and validating the encodings.
+	Elapsed time.Duration `json:"encode_ns"`
+}
+
+// MBPerSec returns the rate at which the trees were encoded, or 0 if no time
+// was measured.
+func (s SerializeStats) MBPerSec() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Encoded) / (1 << 20) / s.Elapsed.Seconds()
+}
+
+// String formats s for a line of text output.
+func (s SerializeStats) String() string {
+	return fmt.Sprintf("encoded MB: %0.1f buffer MB: %0.1f encode MB/sec: %0.1f",
+		float64(s.Encoded)/(1<<20), float64(s.Buffers)/(1<<20), s.MBPerSec())
+}
+
+// treeEncoder encodes a tree in preorder into a buffer allocated from an
+// allocator, as a byte of flags for the children of each node followed by
+// its preorder index as a uvarint. When the buffer is full, it allocates one
+// twice the size from the same allocator and copies the encoding over,
+// leaving the old buffer behind.
+type treeEncoder[T any] struct {
+	alloc   Allocator[T]
+	buf     []byte
+	n       int // bytes encoded
+	nodes   uint64
+	buffers int // bytes of all the buffers allocated
+}
+
+// grow makes room for at least need more bytes.
+func (e *treeEncoder[T]) grow(need int) {
+	if e.n+need <= len(e.buf) {
+		return
+	}
+	size := 2 * len(e.buf)
+	if size < serializeBufSize {
+		size = serializeBufSize
+	}
+	for size < e.n+need {
+		size *= 2
+	}
+	buf := allocBytes(e.alloc, size)
+	copy(buf, e.buf[:e.n])
+	e.buf = buf
+	e.buffers += size
+}
+
+// encode appends the encoding of t and its subtrees.
+func (e *treeEncoder[T]) encode(t *Tree[T]) {
+	e.grow(1 + binary.MaxVarintLen64)
+	var flags byte
+	if t.Left != nil {
+		flags |= 1
+	}
+	if t.Right != nil {
+		flags |= 2
+	}
+	e.buf[e.n] = flags
+	e.n++
+	e.n += binary.PutUvarint(e.buf[e.n:], e.nodes)
+	e.nodes++
+	if t.Left != nil {
+		e.encode(t.Left)
+	}
+	if t.Right != nil {
+		e.encode(t.Right)
+	}
+}
+
+// checksum returns the FNV-1a hash of b.
+func checksum(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// serializeTrees builds a complete tree of depth and encodes it with a
+// treeEncoder allocating from the worker's allocator. The checksum of the
+// finished buffer must match that of the encoding computed by walking the
+// tree again, which it does not if a grown buffer was copied wrongly. The
+// returned bytes include all the buffers.
+func serializeTrees[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	tree := w.r.build(depth, w.alloc)
+	nodes = tree.Count()
+	if w.r.badCount(depth, nodes) {
+		w.countErrors++
+	}
+
+	e := treeEncoder[T]{alloc: w.alloc}
+	encodeStart := time.Now()
+	e.encode(tree)
+	elapsed := time.Since(encodeStart)
+
+	if e.nodes != uint64(nodes) {
+		panic(fmt.Sprintf("bintree: encoded %d nodes of a tree of %d", e.nodes, nodes))
+	}
+	if sum, want := checksum(e.buf[:e.n]), checksumEncoding(tree); sum != want {
+		panic(fmt.Sprintf("bintree: encoded tree has checksum %#x, want %#x", sum, want))
+	}
+	if w.releaser != nil {
+		w.releaser.ReleaseTree(tree)
+	}
+
+	s := &w.serialize
+	s.Encoded += e.n
+	s.Buffers += e.buffers
+	s.Elapsed += elapsed
+	return nodes, nodes*w.r.nodeSize + e.buffers
+}
+
+// checksumEncoding returns the checksum of the encoding of t without
+// storing it, to validate the encoded buffer.
+func checksumEncoding[T any](t *Tree[T]) uint64 {
+	h := uint64(14695981039346656037)
+	var scratch [1 + binary.MaxVarintLen64]byte
+	var index uint64
+	var walk func(t *Tree[T])
+	walk = func(t *Tree[T]) {
+		scratch[0] = 0
+		if t.Left != nil {
+			scratch[0] |= 1
+		}
+		if t.Right != nil {
+			scratch[0] |= 2
+		}
+		n := 1 + binary.PutUvarint(scratch[1:], index)
+		index++
+		for _, c := range scratch[:n] {
+			h ^= uint64(c)
+			h *= 1099511628211
+		}
+		if t.Left != nil {
+			walk(t.Left)
+		}
+		if t.Right != nil {
+			walk(t.Right)
+		}
+	}
+	walk(t)
+	return h
+}
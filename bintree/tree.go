@@ -0,0 +1,184 @@
+// Package bintree implements the binary-trees benchmark from the Benchmarks
+// Game, modified to allocate its trees from memory arenas or other pluggable
+// allocation strategies. See the main package for the origin and license of
+// this code.
+package bintree
+
+import "arena"
+
+// Tree is a binary tree node. Value is the node's payload; a byte array
+// payload grows the node size (see Config.Padding).
+//
+// Value comes first so that an empty struct{} payload takes no space: a
+// trailing zero-size field would be padded to keep its address inside the node.
+type Tree[T any] struct {
+	Value T
+	Left  *Tree[T]
+	Right *Tree[T]
+}
+
+// Count the nodes in the given complete binary tree.
+func (t *Tree[T]) Count() int {
+	// Only test the Left node (this binary tree is expected to be complete).
+	if t.Left == nil {
+		return 1
+	}
+	return 1 + t.Right.Count() + t.Left.Count()
+}
+
+// CountAll counts the nodes in the given binary tree, which need not be
+// complete. It returns 0 for a nil tree.
+func (t *Tree[T]) CountAll() int {
+	if t == nil {
+		return 0
+	}
+	return 1 + t.Left.CountAll() + t.Right.CountAll()
+}
+
+// Create a complete binary tree of `depth` and return it as a pointer.
+// A nil allocator allocates from the regular heap.
+func NewTree[T any](depth int, a Allocator[T]) *Tree[T] {
+	if b, ok := treeBuilder(a); ok {
+		return b.BuildTree(depth)
+	}
+	return newTree(depth, a)
+}
+
+// NewTreeIterative is NewTree, building the tree with a loop and an
+// explicit stack instead of recursion. The nodes are allocated in the same
+// post-order as NewTree: both subtrees before their parent.
+func NewTreeIterative[T any](depth int, a Allocator[T]) *Tree[T] {
+	if b, ok := treeBuilder(a); ok {
+		return b.BuildTree(depth)
+	}
+
+	// The stack holds the completed left subtrees still waiting for their
+	// right sibling, deepest first, so it never has more than depth entries.
+	type subtree struct {
+		t     *Tree[T]
+		depth int
+	}
+	var stack [64]subtree
+	n := 0
+	for {
+		t, d := allocTreeNode(a), 0
+		for n > 0 && stack[n-1].depth == d {
+			n--
+			parent := allocTreeNode(a)
+			parent.Left = stack[n].t
+			parent.Right = t
+			t, d = parent, d+1
+		}
+		if d == depth {
+			return t
+		}
+		stack[n] = subtree{t, d}
+		n++
+	}
+}
+
+// NewTreeLevelOrder is NewTree, allocating the nodes level by level, root
+// first, so that the top of the tree is contiguous in an arena. It needs no
+// scratch space: until its own children are allocated, each node of the
+// deepest level so far links to the next node of its level through Left.
+func NewTreeLevelOrder[T any](depth int, a Allocator[T]) *Tree[T] {
+	if b, ok := treeBuilder(a); ok {
+		return b.BuildTree(depth)
+	}
+
+	root := allocTreeNode(a)
+	level := root
+	for d := 0; d < depth; d++ {
+		var first, last *Tree[T]
+		for parent := level; parent != nil; {
+			next := parent.Left
+			left, right := allocTreeNode(a), allocTreeNode(a)
+			parent.Left, parent.Right = left, right
+			left.Left = right
+			if last == nil {
+				first = left
+			} else {
+				last.Left = left
+			}
+			last = right
+			parent = next
+		}
+		level = first
+	}
+	// Unlink the leaves.
+	for leaf := level; leaf != nil; {
+		next := leaf.Left
+		leaf.Left = nil
+		leaf = next
+	}
+	return root
+}
+
+// treeBuilder returns a as a TreeBuilder if it builds whole trees. A
+// payload wrapper only does if the allocator it wraps does.
+func treeBuilder[T any](a Allocator[T]) (TreeBuilder[T], bool) {
+	if p, ok := a.(*payloadAllocator[T]); ok {
+		if _, ok := p.Allocator.(TreeBuilder[T]); !ok {
+			return nil, false
+		}
+	}
+	b, ok := a.(TreeBuilder[T])
+	return b, ok
+}
+
+// newTree recursively creates a complete binary tree of `depth`, one node at a time.
+func newTree[T any](depth int, a Allocator[T]) *Tree[T] {
+	// thepudds: alloc via an arena if we have one.
+	if depth > 0 {
+		// thepudds: note that for this particular benchmark, it is faster to create the
+		// left and right sub-trees before allocating our own tree node.
+		// Otherwise, we could eliminate a couple of lines here.
+		left := newTree(depth-1, a)
+		right := newTree(depth-1, a)
+		treePtr := allocTreeNode(a)
+		treePtr.Left = left
+		treePtr.Right = right
+		return treePtr
+	} else {
+		return allocTreeNode(a)
+	}
+}
+
+// CloneTree returns a deep copy of t allocated on the regular heap.
+// arena.Clone only copies a single node, so the children are cloned recursively.
+func CloneTree[T any](t *Tree[T]) *Tree[T] {
+	if t == nil {
+		return nil
+	}
+	c := arena.Clone(t)
+	c.Left = CloneTree(t.Left)
+	c.Right = CloneTree(t.Right)
+	return c
+}
+
+// CopyTree returns a deep copy of t allocated from dst, so that t's own
+// arena can be freed. Payloads are copied by value.
+func CopyTree[T any](dst *arena.Arena, t *Tree[T]) *Tree[T] {
+	return copyTree(&countingArena{arena: dst}, t)
+}
+
+// copyTree is CopyTree, counting the bytes allocated from dst.
+func copyTree[T any](dst *countingArena, t *Tree[T]) *Tree[T] {
+	if t == nil {
+		return nil
+	}
+	c := arenaNew[Tree[T]](dst)
+	c.Value = t.Value
+	c.Left = copyTree(dst, t.Left)
+	c.Right = copyTree(dst, t.Right)
+	return c
+}
+
+// Allocate an empty tree node, using an allocator if provided.
+func allocTreeNode[T any](a Allocator[T]) *Tree[T] {
+	if a != nil {
+		return a.NewTreeNode()
+	} else {
+		return &Tree[T]{}
+	}
+}
@@ -0,0 +1,46 @@
+package bintree
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+)
+
+var benchDepths = []int{4, 10, 16}
+
+func BenchmarkNewTreeHeap(b *testing.B) {
+	for _, depth := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			benchmarkNewTree[struct{}](b, depth, HeapAllocator[struct{}]{})
+		})
+	}
+}
+
+func BenchmarkNewTreeArena(b *testing.B) {
+	for _, depth := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			a := NewArenaAllocator[struct{}]()
+			defer a.Free()
+			benchmarkNewTree[struct{}](b, depth, a)
+		})
+	}
+}
+
+// benchmarkNewTree builds a tree of depth per iteration, resetting a once it
+// has allocated more than 1 MB, mirroring the -minalloc default.
+func benchmarkNewTree[T any](b *testing.B, depth int, a Allocator[T]) {
+	const minAlloc = 1 << 20
+
+	allocated := 0
+	nodes := 0
+	for i := 0; i < b.N; i++ {
+		if allocated > minAlloc {
+			a.Reset()
+			allocated = 0
+		}
+		n := NewTree(depth, a).Count()
+		nodes += n
+		allocated += n * int(unsafe.Sizeof(Tree[T]{}))
+	}
+	b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+}
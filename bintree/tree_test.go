@@ -0,0 +1,194 @@
+package bintree
+
+import (
+	"arena"
+	"fmt"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestNewTree(t *testing.T) {
+	allocs := []struct {
+		name string
+		new  func() Allocator[struct{}]
+	}{
+		{"nil", func() Allocator[struct{}] { return nil }},
+		{"arena", func() Allocator[struct{}] { return NewArenaAllocator[struct{}]() }},
+	}
+	builds := []struct {
+		name  string
+		build func(depth int, a Allocator[struct{}]) *Tree[struct{}]
+	}{
+		{"recursive", NewTree[struct{}]},
+		{"iterative", NewTreeIterative[struct{}]},
+		{"levelorder", NewTreeLevelOrder[struct{}]},
+	}
+	for _, build := range builds {
+		for _, alloc := range allocs {
+			for depth := 0; depth <= 16; depth++ {
+				t.Run(fmt.Sprintf("%s/%s/depth=%d", build.name, alloc.name, depth), func(t *testing.T) {
+					a := alloc.new()
+					if a != nil {
+						defer a.Free()
+					}
+
+					tree := build.build(depth, a)
+					if got, want := tree.Count(), 1<<(depth+1)-1; got != want {
+						t.Errorf("Count() = %d, want %d", got, want)
+					}
+					checkComplete(t, tree, depth)
+				})
+			}
+		}
+	}
+}
+
+// orderAllocator numbers the nodes it allocates in allocation order.
+type orderAllocator struct {
+	HeapAllocator[int]
+	n int
+}
+
+func (a *orderAllocator) NewTreeNode() *Tree[int] {
+	a.n++
+	return &Tree[int]{Value: a.n}
+}
+
+func TestNewTreeLevelOrder(t *testing.T) {
+	const depth = 6
+	t.Run("plain", func(t *testing.T) { testLevelOrder(t, depth, &orderAllocator{}) })
+	// A payload wrapper must not make the tree be built recursively.
+	wrapped := &payloadAllocator[int]{Allocator: &orderAllocator{}, fill: func(*int, int) {}}
+	t.Run("payload", func(t *testing.T) { testLevelOrder(t, depth, wrapped) })
+}
+
+func testLevelOrder(t *testing.T, depth int, a Allocator[int]) {
+	tree := NewTreeLevelOrder[int](depth, a)
+	// Numbered from 1 in level order, the children of node i are nodes 2i
+	// and 2i+1.
+	level := []*Tree[int]{tree}
+	for d := 0; d <= depth; d++ {
+		var next []*Tree[int]
+		for _, node := range level {
+			if node.Left == nil {
+				if d != depth {
+					t.Fatalf("node %d at depth %d has no children", node.Value, d)
+				}
+				continue
+			}
+			if node.Left.Value != 2*node.Value || node.Right.Value != 2*node.Value+1 {
+				t.Fatalf("children of node %d are %d and %d", node.Value, node.Left.Value, node.Right.Value)
+			}
+			next = append(next, node.Left, node.Right)
+		}
+		level = next
+	}
+}
+
+func TestNewTreeIterativeOrder(t *testing.T) {
+	const depth = 10
+	recursive := NewTree[int](depth, &orderAllocator{})
+	iterative := NewTreeIterative[int](depth, &orderAllocator{})
+	var walk func(r, i *Tree[int])
+	walk = func(r, i *Tree[int]) {
+		if r.Value != i.Value {
+			t.Fatalf("iterative node allocated %dth, recursive %dth", i.Value, r.Value)
+		}
+		if r.Left != nil {
+			walk(r.Left, i.Left)
+			walk(r.Right, i.Right)
+		}
+	}
+	walk(recursive, iterative)
+}
+
+// checkComplete reports an error if t is not a complete binary tree of depth.
+func checkComplete[T any](t *testing.T, tree *Tree[T], depth int) {
+	t.Helper()
+	if tree == nil {
+		t.Fatal("nil node")
+	}
+	if depth == 0 {
+		if tree.Left != nil || tree.Right != nil {
+			t.Fatal("leaf has children")
+		}
+		return
+	}
+	if tree.Left == nil || tree.Right == nil {
+		t.Fatalf("internal node at remaining depth %d is missing a child", depth)
+	}
+	checkComplete(t, tree.Left, depth-1)
+	checkComplete(t, tree.Right, depth-1)
+}
+
+func TestNodeSize(t *testing.T) {
+	if got, want := unsafe.Sizeof(Tree[struct{}]{}), 2*unsafe.Sizeof(uintptr(0)); got != want {
+		t.Errorf("unsafe.Sizeof(Tree[struct{}]{}) = %d, want %d", got, want)
+	}
+}
+
+func TestCountLeaf(t *testing.T) {
+	if got := (&Tree[struct{}]{}).Count(); got != 1 {
+		t.Errorf("Count() = %d, want 1", got)
+	}
+}
+
+func TestArenaFree(t *testing.T) {
+	const depth = 10
+
+	a := NewArenaAllocator[struct{}]()
+	tree := NewTree[struct{}](depth, a)
+	if got, want := tree.Count(), 1<<(depth+1)-1; got != want {
+		t.Fatalf("Count() = %d, want %d", got, want)
+	}
+	clone := CloneTree(tree)
+	tree = nil
+	a.Free()
+
+	// The allocator must drop its reference to the freed arena, and the heap
+	// clone must not share any memory with it.
+	if a.arena != nil {
+		t.Error("allocator still references its arena after Free")
+	}
+	runtime.GC()
+	if got, want := clone.Count(), 1<<(depth+1)-1; got != want {
+		t.Errorf("clone Count() = %d, want %d", got, want)
+	}
+	checkComplete(t, clone, depth)
+}
+
+func TestCopyTree(t *testing.T) {
+	const depth = 10
+	src := NewArenaAllocator[int]()
+	tree := NewTree[int](depth, src)
+	var number func(t *Tree[int], i int)
+	number = func(t *Tree[int], i int) {
+		t.Value = i
+		if t.Left != nil {
+			number(t.Left, 2*i)
+			number(t.Right, 2*i+1)
+		}
+	}
+	number(tree, 1)
+
+	dst := arena.NewArena()
+	defer dst.Free()
+	c := CopyTree(dst, tree)
+	// Any pointer left into the freed source arena faults below.
+	src.Free()
+	runtime.GC()
+
+	checkComplete(t, c, depth)
+	var check func(t2 *Tree[int], i int)
+	check = func(t2 *Tree[int], i int) {
+		if t2.Value != i {
+			t.Fatalf("copied node %d has value %d", i, t2.Value)
+		}
+		if t2.Left != nil {
+			check(t2.Left, 2*i)
+			check(t2.Right, 2*i+1)
+		}
+	}
+	check(c, 1)
+}
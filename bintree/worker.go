@@ -0,0 +1,368 @@
+package bintree
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// treeJob is a unit of per-depth work: build iterations trees of depth,
+// store the result in out and call done.
+type treeJob struct {
+	depth, iterations int
+	out               *Result
+	progress          *depthProgress
+	done              func()
+}
+
+// treeWorker builds trees, resetting its allocator whenever it has allocated
+// more than cfg.MinAllocMB.
+type treeWorker[T any] struct {
+	r         *runner[T]
+	alloc     Allocator[T]
+	releaser  TreeReleaser[T]
+	counter   ByteCounter
+	leaker    ArenaLeaker
+	allocated int
+
+	// sinceReset is the number of iterations since the allocator was last
+	// reset, for the interval free mode.
+	sinceReset int
+
+	// iteration is the index of the current iteration of buildTrees, or -1
+	// during the warmup.
+	iteration int
+
+	// rng is seeded per depth from cfg.Seed, so randomized workloads are
+	// reproducible regardless of which worker handles which depth.
+	rng *rand.Rand
+
+	// countElapsed is the time the tree workload has spent counting its
+	// trees, as opposed to building them.
+	countElapsed time.Duration
+
+	// countErrors is the number of trees of the tree workload that had
+	// the wrong node count.
+	countErrors int
+
+	// last is the most recent tree of the tree workload with Compact,
+	// which the worker copies into its survivor arena before resetting the
+	// scratch arena holding it. The survivor arena lives as long as the
+	// worker.
+	last     *Tree[T]
+	survivor *countingArena
+	compact  CompactStats
+
+	// mutate is the live tree of the mutate workload.
+	mutate mutator[T]
+
+	// serialize accumulates the encoding statistics of the serialize
+	// workload.
+	serialize SerializeStats
+
+	// encoded is the encoding of a complete tree of the current depth that
+	// the deserialize workload decodes.
+	encoded []byte
+
+	// index holds the root of every tree built since the allocator was
+	// last reset with RootIndex, a heap slice pointing into the arena.
+	index []*Tree[T]
+
+	// latency is the histogram of the time taken by each iteration of the
+	// current depth, including the reset before it, if any, and reservoir
+	// samples the same times after the first iteration for the quantiles.
+	latency   LatencyHistogram
+	reservoir latencyReservoir
+
+	// log logs the lifecycle of the worker's arenas with Config.ArenaLog,
+	// and is nil otherwise.
+	log *arenaLog
+}
+
+// newTreeWorker returns a worker with a fresh allocator.
+func (r *runner[T]) newTreeWorker() *treeWorker[T] {
+	w := &treeWorker[T]{r: r, alloc: r.newWorkerAlloc()}
+	if !r.cfg.ArenaPool {
+		r.arenaEvents(w.alloc.Arenas(), 0, 0)
+		w.log = r.newWorkerArenaLog()
+		w.log.created(w.alloc.Arenas())
+	}
+	w.releaser, _ = w.alloc.(TreeReleaser[T])
+	w.counter, _ = byteCounter(w.alloc)
+	w.leaker, _ = arenaLeaker(w.alloc)
+	if r.cfg.RootIndex {
+		w.index = make([]*Tree[T], 0, 64)
+	}
+	return w
+}
+
+// buildTrees builds iterations trees of depth, counting each one, and returns
+// their statistics. The Arenas count includes the arena the worker started
+// with. Progress is published to progress, which may be nil.
+//
+// If cfg.BenchTime is set, buildTrees ignores iterations and builds trees
+// until BenchTime has elapsed.
+//
+// If cfg.Cancel is closed, buildTrees stops before the next tree and returns
+// the statistics of the trees built so far, marked Partial, or a zero Result
+// if it built none.
+func (w *treeWorker[T]) buildTrees(depth, iterations int, progress *depthProgress) Result {
+	seed := w.r.cfg.Seed + int64(depth)
+	w.rng = rand.New(rand.NewSource(seed))
+	w.log.setDepth(depth)
+	if w.r.cfg.Workload == "deserialize" {
+		w.encoded = encodeTree[T](depth)
+		defer func() { w.encoded = nil }()
+	}
+	if w.r.cfg.Warmup > 0 || w.r.cfg.WarmupTime > 0 {
+		w.warmUp(depth)
+		w.rng.Seed(seed)
+	}
+	w.countElapsed, w.countErrors = 0, 0
+	w.last, w.compact = nil, CompactStats{}
+	w.mutate = mutator[T]{}
+	w.serialize = SerializeStats{}
+	w.latency = LatencyHistogram{}
+	w.reservoir.reset(seed)
+
+	start := time.Now()
+	startArenas := w.alloc.Arenas()
+	startStats := allocStats(w.alloc)
+	startFree := freeElapsed(w.alloc)
+
+	arenas := func() int {
+		arenas := w.alloc.Arenas() - startArenas
+		if startArenas > 0 {
+			arenas++
+		}
+		return arenas
+	}
+
+	expired, stopTimer := deadline(w.r.cfg.BenchTime)
+	defer stopTimer()
+	if w.r.cfg.BenchTime > 0 {
+		iterations = math.MaxInt
+	}
+
+	nodes, bytes, built, reported := 0, 0, 0, 0
+	last := start
+	for ; built < iterations && !expired.Load(); built++ {
+		if stopped(w.r.cfg.Cancel) {
+			break
+		}
+		// thepudds: we reuse each arena until it has allocated more than minAllocMB.
+		if w.needsReset() {
+			w.compactLast()
+			w.reset()
+		}
+		w.iteration = built
+		newNodes, newBytes := w.runWorkload(depth)
+		// One clock read per iteration: each one ends where the previous
+		// one did.
+		now := time.Now()
+		w.latency.record(now.Sub(last))
+		if built > 0 {
+			// The first tree of a depth pays for faulting in its arena.
+			w.reservoir.record(now.Sub(last))
+		}
+		last = now
+		nodes += newNodes
+		bytes += newBytes
+		w.allocated += newBytes
+		w.sinceReset++
+		w.r.live.add(newBytes)
+		w.r.cfg.Progress.built(1, newNodes)
+		progress.add(1, arenas()-reported)
+		reported = arenas()
+	}
+	if built == 0 {
+		return Result{}
+	}
+
+	res := Result{
+		Kind:       KindDepth,
+		Iterations: built,
+		Depth:      depth,
+		Arenas:     arenas(),
+		Nodes:      nodes,
+		Bytes:      bytes,
+		Elapsed:    time.Since(start),
+		Alloc:      allocStats(w.alloc).sub(startStats).orNil(),
+
+		CountElapsed: w.countElapsed,
+		CountErrors:  w.countErrors,
+		FreeElapsed:  freeElapsed(w.alloc) - startFree,
+
+		Partial: built < iterations && !expired.Load(),
+		timed:   w.r.cfg.BenchTime > 0,
+		unit:    workloadUnits[w.r.cfg.Workload],
+		inserts: w.r.cfg.Workload == "bst",
+		decodes: w.r.cfg.Workload == "deserialize",
+	}
+	if w.r.cfg.Compact {
+		compact := w.compact
+		res.Compact = &compact
+	}
+	if w.r.cfg.Workload == "mutate" {
+		w.mutate.drop()
+		mutate := w.mutate.stats
+		res.Mutate = &mutate
+	}
+	if w.r.cfg.Workload == "serialize" {
+		serialize := w.serialize
+		res.Serialize = &serialize
+	}
+	latency := w.latency
+	latency.Bounds = LatencyBounds
+	res.Latency = &latency
+	res.latencySample = w.reservoir.sample()
+	res.P50, res.P95, res.P99 = latencyQuantiles(res.latencySample)
+	res.MaxTree = w.reservoir.max
+	return res
+}
+
+// warmUp runs cfg.Warmup iterations of the workload at depth, or runs it for
+// cfg.WarmupTime, recycling the allocator as buildTrees does. It then resets
+// the allocator, so the measured trees start from a fresh arena with no
+// minalloc accounting carried over from the warmup.
+func (w *treeWorker[T]) warmUp(depth int) {
+	iterations := w.r.cfg.Warmup
+	if w.r.cfg.WarmupTime > 0 {
+		iterations = math.MaxInt
+	}
+	expired, stopTimer := deadline(w.r.cfg.WarmupTime)
+	defer stopTimer()
+
+	w.iteration = -1
+	for i := 0; i < iterations && !expired.Load(); i++ {
+		if stopped(w.r.cfg.Cancel) {
+			break
+		}
+		if w.needsReset() {
+			w.reset()
+		}
+		_, newBytes := w.runWorkload(depth)
+		w.allocated += newBytes
+		w.sinceReset++
+		w.r.live.add(newBytes)
+	}
+	w.reset()
+}
+
+// runWorkload runs one iteration of the workload at depth. If the allocator
+// counts the bytes it allocates, those are returned rather than the
+// workload's own estimate.
+func (w *treeWorker[T]) runWorkload(depth int) (nodes, bytes int) {
+	if w.counter == nil {
+		return w.r.workload(w, depth)
+	}
+	before := w.counter.AllocatedBytes()
+	nodes, _ = w.r.workload(w, depth)
+	return nodes, w.counter.AllocatedBytes() - before
+}
+
+// FreeModes lists the supported Config.FreeMode names.
+var FreeModes = []string{"mb", "end", "never", "interval"}
+
+func validFreeMode(mode string) bool {
+	for _, m := range FreeModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// needsReset reports whether the worker should reset its allocator before
+// the next iteration, as cfg.FreeMode decides: once it has allocated more
+// than cfg.MinAllocMB, every cfg.FreeEvery iterations, or only when the
+// worker is done. With an ArenaPool, which applies the budget itself, it
+// resets after every iteration.
+func (w *treeWorker[T]) needsReset() bool {
+	if w.r.cfg.ArenaPool {
+		return w.allocated > 0
+	}
+	switch w.r.cfg.FreeMode {
+	case "end":
+		return false
+	case "interval":
+		return w.sinceReset >= w.r.cfg.FreeEvery
+	default:
+		return w.allocated > w.r.cfg.minAllocBytes()
+	}
+}
+
+// reset releases everything the worker has allocated. In the never free
+// mode, it replaces the arena without freeing the old one.
+func (w *treeWorker[T]) reset() {
+	w.clearIndex()
+	w.mutate.drop()
+	before := w.alloc.Arenas()
+	freed := true
+	if w.r.cfg.FreeMode == "never" && w.leaker != nil {
+		w.leaker.Leak()
+		freed = false
+	} else {
+		freeStart := freeElapsed(w.alloc)
+		w.alloc.Reset()
+		w.r.arenas.freeTime(freeElapsed(w.alloc) - freeStart)
+	}
+	// An ArenaPool records its own arenas, as it frees only some of them.
+	if replaced := w.alloc.Arenas() - before; replaced > 0 && !w.r.cfg.ArenaPool {
+		if freed {
+			w.r.arenaEvents(replaced, replaced, w.allocated)
+		} else {
+			w.r.arenaEvents(replaced, 0, 0)
+		}
+		w.log.freed(w.allocated, w.sinceReset, !freed)
+		w.log.created(replaced)
+	}
+	w.r.live.add(-w.allocated)
+	w.allocated = 0
+	w.sinceReset = 0
+}
+
+// clearIndex drops the index's pointers to the roots of the worker's trees,
+// which must happen before the arena holding them is freed, keeping the
+// slice for the next ones.
+func (w *treeWorker[T]) clearIndex() {
+	for i := range w.index {
+		w.index[i] = nil
+	}
+	w.index = w.index[:0]
+}
+
+// deadline returns a flag that is set once d has elapsed, or never if d is
+// not positive, and a function to stop the timer. Loops check the flag
+// rather than reading the clock, which would be a measurable cost for the
+// smallest trees.
+func deadline(d time.Duration) (expired *atomic.Bool, stop func()) {
+	expired = new(atomic.Bool)
+	if d <= 0 {
+		return expired, func() {}
+	}
+	timer := time.AfterFunc(d, func() { expired.Store(true) })
+	return expired, func() { timer.Stop() }
+}
+
+// free releases the worker's allocator.
+func (w *treeWorker[T]) free() {
+	w.clearIndex()
+	w.mutate.drop()
+	w.freeSurvivor()
+	switch {
+	case w.r.cfg.ArenaPool:
+		w.alloc.Free()
+	case w.r.cfg.FreeMode == "never" && w.leaker != nil:
+		// The last arena is left for the GC as well.
+		w.log.freed(w.allocated, w.sinceReset, true)
+	default:
+		freeStart := freeElapsed(w.alloc)
+		w.r.freeAllocator(w.alloc)
+		w.r.arenas.freeTime(freeElapsed(w.alloc) - freeStart)
+		w.log.freed(w.allocated, w.sinceReset, false)
+	}
+	w.log.flush()
+	w.r.live.add(-w.allocated)
+}
@@ -0,0 +1,248 @@
+package bintree
+
+import (
+	"fmt"
+	"time"
+)
+
+// Workloads lists the supported Config.Workload names.
+var Workloads = []string{"tree", "random", "list", "map", "bytes", "mutate", "nary", "bst", "serialize", "deserialize"}
+
+// workloadUnits names what the per-depth iterations of each workload build,
+// for the text output.
+var workloadUnits = map[string]string{
+	"list":   "lists",
+	"map":    "maps",
+	"bytes":  "batches",
+	"mutate": "mutations",
+}
+
+// int64Workload reports whether the workload stores int64 keys or values in
+// the node payload.
+func int64Workload(workload string) bool {
+	return workload == "random" || workload == "list" || workload == "bst"
+}
+
+// BuildModes lists the supported Config.Build names.
+var BuildModes = []string{"recursive", "iterative"}
+
+func validBuild(build string) bool {
+	for _, b := range BuildModes {
+		if b == build {
+			return true
+		}
+	}
+	return false
+}
+
+// Layouts lists the supported Config.Layout names.
+var Layouts = []string{"postorder", "levelorder"}
+
+func validLayout(layout string) bool {
+	for _, l := range Layouts {
+		if l == layout {
+			return true
+		}
+	}
+	return false
+}
+
+// buildFunc returns the function building complete trees for cfg.Layout
+// and cfg.Build.
+func buildFunc[T any](cfg *Config) func(depth int, a Allocator[T]) *Tree[T] {
+	switch {
+	case cfg.Layout == "levelorder":
+		return NewTreeLevelOrder[T]
+	case cfg.Build == "iterative":
+		return NewTreeIterative[T]
+	default:
+		return NewTree[T]
+	}
+}
+
+func validWorkload(workload string) bool {
+	for _, wl := range Workloads {
+		if wl == workload {
+			return true
+		}
+	}
+	return false
+}
+
+// workload performs one iteration of per-depth work with the worker's
+// allocator, returning the number of nodes allocated and their size in bytes.
+type workload[T any] func(w *treeWorker[T], depth int) (nodes, bytes int)
+
+// completeTrees builds and counts a complete binary tree of depth, adding
+// the time taken to count it to the worker's countElapsed.
+func completeTrees[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	tree := w.r.build(depth, w.alloc)
+	countStart := time.Now()
+	nodes = tree.Count()
+	w.countElapsed += time.Since(countStart)
+	if w.r.badCount(depth, nodes) {
+		w.countErrors++
+	}
+	if w.r.cfg.Compact {
+		w.last = tree
+	}
+	if w.r.cfg.RootIndex {
+		w.index = append(w.index, tree)
+	}
+	if w.iteration >= 0 && w.r.leaks.sampled(w.iteration) {
+		w.r.leaks.track(tree, depth, w.iteration)
+	}
+	if w.releaser != nil {
+		w.releaser.ReleaseTree(tree)
+	}
+	return nodes, nodes * w.r.nodeSize
+}
+
+// workloadFunc returns the generic workload named by cfg.Workload.
+// Workloads with an int64 payload are set up by Run.
+func workloadFunc[T any](cfg *Config) workload[T] {
+	switch cfg.Workload {
+	case "map":
+		return buildMaps[T]
+	case "bytes":
+		return byteSlices[T]
+	case "mutate":
+		return mutateTrees[T]
+	case "nary":
+		return naryTrees[T]
+	case "serialize":
+		return serializeTrees[T]
+	case "deserialize":
+		return deserializeTrees[T]
+	default:
+		return completeTrees[T]
+	}
+}
+
+// buildMaps fills a map with 2^(depth+1)-1 entries, as many as a complete
+// tree of depth has nodes, whose values are nodes from the worker's
+// allocator, then reads every entry back to validate a checksum. The map's
+// own buckets always live on the GC heap.
+func buildMaps[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	n := 1<<(depth+1) - 1
+	m := make(map[int]*Tree[T], n)
+	for i := 0; i < n; i++ {
+		m[i] = allocTreeNode(w.alloc)
+	}
+
+	sum := 0
+	for k, v := range m {
+		if v != nil {
+			sum += k
+			nodes++
+		}
+	}
+	if want := n * (n - 1) / 2; nodes != n || sum != want {
+		panic(fmt.Sprintf("bintree: map has %d values with checksum %d, want %d and %d", nodes, sum, n, want))
+	}
+	return nodes, nodes * w.r.nodeSize
+}
+
+// byteSlices allocates byte buffers from the worker's allocator, cycling
+// through cfg.SliceSizes, until it has requested as many bytes as a complete
+// tree of depth occupies. Each buffer is filled with a pattern and the
+// buffers are then read back to validate a checksum. The returned nodes are
+// the number of buffers.
+func byteSlices[T any](w *treeWorker[T], depth int) (nodes, bytes int) {
+	sizes := w.r.cfg.SliceSizes
+	target := (1<<(depth+1) - 1) * w.r.nodeSize
+	var bufs [][]byte
+	for bytes < target {
+		b := allocBytes(w.alloc, sizes[nodes%len(sizes)])
+		for i := range b {
+			b[i] = byte(nodes)
+		}
+		bufs = append(bufs, b)
+		nodes++
+		bytes += len(b)
+	}
+
+	sum, want := 0, 0
+	for i, b := range bufs {
+		for _, c := range b {
+			sum += int(c)
+		}
+		want += len(b) * int(byte(i))
+	}
+	if sum != want {
+		panic(fmt.Sprintf("bintree: byte buffers have checksum %d, want %d", sum, want))
+	}
+	return nodes, bytes
+}
+
+// randomTrees builds an unbalanced tree by inserting 2^(depth+1)-1
+// pseudo-random keys BST-style, the same number of nodes as a complete tree
+// of depth, and validates it by counting the inserted nodes.
+func randomTrees(w *treeWorker[int64], depth int) (nodes, bytes int) {
+	n := 1<<(depth+1) - 1
+	var root *Tree[int64]
+	for i := 0; i < n; i++ {
+		root = insertKey(root, w.rng.Int63(), w.alloc)
+	}
+	nodes = root.CountAll()
+	if nodes != n {
+		panic(fmt.Sprintf("bintree: random tree has %d nodes, inserted %d", nodes, n))
+	}
+	return nodes, nodes * w.r.nodeSize
+}
+
+// insertKey inserts key into the binary search tree rooted at root,
+// allocating the new node from a, and returns the root. Duplicate keys are
+// inserted to the right.
+func insertKey(root *Tree[int64], key int64, a Allocator[int64]) *Tree[int64] {
+	node := allocTreeNode(a)
+	node.Value = key
+	if root == nil {
+		return node
+	}
+	for t := root; ; {
+		if key < t.Value {
+			if t.Left == nil {
+				t.Left = node
+				return root
+			}
+			t = t.Left
+		} else {
+			if t.Right == nil {
+				t.Right = node
+				return root
+			}
+			t = t.Right
+		}
+	}
+}
+
+// linkedLists builds a singly-linked list, using Left as the next pointer,
+// of cfg.ListLen nodes, or of 2^(depth+1)-1 nodes (as many as a complete tree
+// of depth) if ListLen is not set. It then walks the list to validate a
+// checksum of the node values.
+func linkedLists(w *treeWorker[int64], depth int) (nodes, bytes int) {
+	n := w.r.cfg.ListLen
+	if n <= 0 {
+		n = 1<<(depth+1) - 1
+	}
+
+	head := allocTreeNode(w.alloc)
+	tail := head
+	for i := 1; i < n; i++ {
+		node := allocTreeNode(w.alloc)
+		node.Value = int64(i)
+		tail.Left = node
+		tail = node
+	}
+
+	var sum int64
+	for l := head; l != nil; l = l.Left {
+		sum += l.Value
+		nodes++
+	}
+	if want := int64(n) * int64(n-1) / 2; nodes != n || sum != want {
+		panic(fmt.Sprintf("bintree: list has %d nodes with checksum %d, want %d and %d", nodes, sum, n, want))
+	}
+	return nodes, nodes * w.r.nodeSize
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+// command is a subcommand, given as the first argument. Each command parses
+// its own flag set, holding the flags that apply to it; without a command,
+// every flag is accepted and the benchmark runs as bench does.
+type command struct {
+	name, args, summary string
+
+	// flags reports whether the flag name applies to the command.
+	flags func(name string) bool
+
+	// run runs the command once its flags are parsed.
+	run func() error
+}
+
+// The flags that apply to only one of the commands other than bench.
+var (
+	sweepOnlyFlags   = []string{"depthsweep", "minallocsweep", "config", "cpus", "scaling", "scalingdepth", "scalingtrees"}
+	microOnlyFlags   = []string{"micro", "microops"}
+	compareOnlyFlags = []string{"compare", "compareorder", "comparebuild", "comparepayload"}
+
+	// microFlags are the other flags the microbenchmarks read.
+	microFlags = []string{"alloc", "noarena", "payload", "padding", "minalloc", "chunknodes", "chunk", "memprofilerate",
+		"loglevel", "logformat"}
+)
+
+var commands = []*command{
+	{
+		name:    "bench",
+		args:    "[flags] [depth]",
+		summary: "run the benchmark once, or -repeat or -count times (the default without a command)",
+		flags: func(name string) bool {
+			return !contains(sweepOnlyFlags, name) && !contains(microOnlyFlags, name) && !contains(compareOnlyFlags, name)
+		},
+		run: benchmark,
+	},
+	{
+		name: "sweep",
+		args: "[flags] [depth]",
+		summary: "run the benchmark over several depths, minalloc values, GOMAXPROCS values or worker counts, " +
+			"or the -config matrix",
+		flags: func(name string) bool {
+			// -scaling -compare runs every worker count with the arena
+			// and the heap allocator.
+			return !contains(microOnlyFlags, name) && (name == "compare" || !contains(compareOnlyFlags, name))
+		},
+		run: func() error {
+			if len(depthSweep) == 0 && len(minAllocSweep) == 0 && len(cpus) == 0 && !*scaling && *configFile == "" {
+				return usageError{errors.New("sweep needs -depthsweep, -minallocsweep, -cpus, -scaling or -config")}
+			}
+			if *compare && !*scaling {
+				return usageError{errors.New("sweep takes -compare only with -scaling")}
+			}
+			return benchmark()
+		},
+	},
+	{
+		name:    "micro",
+		args:    "[flags] " + strings.Join(bintree.Micros, "|"),
+		summary: "run a microbenchmark instead of the tree workload",
+		flags: func(name string) bool {
+			return contains(microOnlyFlags, name) || contains(microFlags, name)
+		},
+		run: func() error {
+			switch flag.NArg() {
+			case 0:
+			case 1:
+				*micro = flag.Arg(0)
+			default:
+				return usageError{errors.New("micro takes one microbenchmark")}
+			}
+			if *micro == "" {
+				return usageError{fmt.Errorf("micro needs a microbenchmark, one of %s", strings.Join(bintree.Micros, ", "))}
+			}
+			return Micro()
+		},
+	},
+	{
+		name:    "compare",
+		args:    "[flags] [depth] | compare [flags] old.json new.json",
+		summary: "compare two results saved with -format=json or -out, or run the arena and heap passes and compare them",
+		flags: func(name string) bool {
+			return !contains(sweepOnlyFlags, name) && !contains(microOnlyFlags, name)
+		},
+		run: func() error {
+			if flag.NArg() == 2 {
+				return compareFiles(flag.Arg(0), flag.Arg(1))
+			}
+			if !*compareBuild && !*comparePayload {
+				*compare = true
+			}
+			return benchmark()
+		},
+	},
+}
+
+// findCommand returns the command named name, or nil if there is none.
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// progName is the name the program was run as, for usage messages.
+func progName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// flagSet returns the flag set of c, sharing the values of the flags of
+// flag.CommandLine that apply to it.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(progName()+" "+c.name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if c.flags(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "usage: %s %s %s\n\n%s\n\nflags:\n", progName(), c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// usage prints the usage of the program without a command, which accepts
+// every flag.
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "usage: %s [command] [flags] [depth]\n\n", progName())
+	printCommands(w)
+	fmt.Fprintf(w, "\nwithout a command, every flag is accepted and the benchmark runs as bench does:\n")
+	flag.PrintDefaults()
+}
+
+// printCommands lists the commands and their summaries.
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "  %-8s %s\n", "help", "list the flags of every command, or print the usage of one")
+}
+
+// help prints the usage of the command named by args, or lists the flags of
+// every command.
+func help(w io.Writer, args []string) error {
+	if len(args) > 0 {
+		c := findCommand(args[0])
+		if c == nil {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		fs := c.flagSet()
+		fs.SetOutput(w)
+		fs.Usage()
+		return nil
+	}
+	fmt.Fprintf(w, "usage: %s [command] [flags] [depth]\n\n", progName())
+	printCommands(w)
+	for _, c := range commands {
+		var names []string
+		c.flagSet().VisitAll(func(f *flag.Flag) { names = append(names, "-"+f.Name) })
+		fmt.Fprintf(w, "\n%s %s %s\n", progName(), c.name, c.args)
+		line := " "
+		for _, name := range names {
+			if len(line)+len(name) > 100 {
+				fmt.Fprintln(w, line)
+				line = " "
+			}
+			line += " " + name
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "\nrun %s help <command> for the usage of each flag\n", progName())
+	return nil
+}
+
+// compareFiles prints the comparison of the results saved in newPath
+// against those saved in oldPath, to the -o file if set.
+func compareFiles(oldPath, newPath string) error {
+	old, err := loadBaseline(oldPath)
+	if err != nil {
+		return fmt.Errorf("could not load %s: %w", oldPath, err)
+	}
+	cur, err := loadBaseline(newPath)
+	if err != nil {
+		return fmt.Errorf("could not load %s: %w", newPath, err)
+	}
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return fmt.Errorf("could not create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	return CompareBaseline(out, oldPath, newPath, old, cur)
+}
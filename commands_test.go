@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+func TestCommandFlags(t *testing.T) {
+	tests := []struct {
+		command, flag string
+		want          bool
+	}{
+		{"bench", "depth", true},
+		{"bench", "depthsweep", false},
+		{"bench", "micro", false},
+		{"bench", "compare", false},
+		{"sweep", "depthsweep", true},
+		{"sweep", "config", true},
+		{"sweep", "microops", false},
+		{"sweep", "compare", true},
+		{"sweep", "comparebuild", false},
+		{"micro", "microops", true},
+		{"micro", "alloc", true},
+		{"micro", "depth", false},
+		{"compare", "comparebuild", true},
+		{"compare", "depth", true},
+		{"compare", "scaling", false},
+	}
+	for _, test := range tests {
+		c := findCommand(test.command)
+		if c == nil {
+			t.Fatalf("no command %s", test.command)
+		}
+		if got := c.flagSet().Lookup(test.flag) != nil; got != test.want {
+			t.Errorf("%s -%s defined = %v, want %v", test.command, test.flag, got, test.want)
+		}
+	}
+	if findCommand("help") != nil {
+		t.Error("help is handled before the commands are looked up")
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("could not create output file"), exitFailed},
+		{fmt.Errorf("%w: %w", errFailed, bintree.ErrCount), exitFailed},
+		{fmt.Errorf("%w: %w", errFailed, bintree.ErrCanceled), exitFailed},
+		{fmt.Errorf("%w: %w", errFailed, bintree.ErrTimeout), exitTimeout},
+		{&bintree.PanicError{Value: "bad tree"}, exitFailed},
+		{usageError{errors.New("-microops must be at least 1")}, exitUsage},
+		{fmt.Errorf("invalid -config: %w", usageError{errors.New("repeat")}), exitUsage},
+	}
+	for _, test := range tests {
+		if got := exitStatus(test.err); got != test.want {
+			t.Errorf("exitStatus(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
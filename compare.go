@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var (
+	compare = flag.Bool("compare", false, "run an arena pass and a heap pass in one process and print the deltas; "+
+		"the passes set the allocator, so it cannot be combined with -alloc or -noarena")
+	compareOrder = flag.String("compareorder", "arena,heap", "comma-separated `order` of the -compare passes; "+
+		"the first pass also warms the page cache")
+	compareBuild = flag.Bool("comparebuild", false, "run a pass for each -build mode in one process and print "+
+		"the per-depth timings side by side")
+	comparePayload = flag.Bool("comparepayload", false, "run a pass with the pointer-free int64 payload and one with "+
+		"the *int64 payload, whose nodes point at heap objects, and print their GC costs")
+)
+
+// passResult holds the summary statistics for one -compare pass.
+type passResult struct {
+	name          string
+	elapsed       time.Duration
+	nodes         int
+	peakHeapInuse uint64
+	numGC         uint32
+	pauseTotal    time.Duration
+	maxPause      time.Duration
+	results       []bintree.Result
+	gc            bintree.GCStats
+
+	// res is the pass's Results, for rendering.
+	res *bintree.Results
+}
+
+func (p passResult) nodesPerSec() float64 {
+	return float64(p.nodes) / p.elapsed.Seconds()
+}
+
+// renderCSV writes the results of p, the i'th pass of a mode running
+// several, to out in the csv format, with the header row only for the
+// first pass, so that the passes make one csv file.
+func (p passResult) renderCSV(i int) error {
+	if p.res == nil {
+		return nil
+	}
+	return p.res.RenderCSV(out, i == 0)
+}
+
+// summary returns the JSON summary of p, labeled with its name.
+func (p passResult) summary() sweepPass {
+	return sweepPass{
+		Value:   p.name,
+		Elapsed: p.elapsed,
+		Totals:  bintree.SumResults(p.results),
+		PeakRSS: p.gc.PeakRSS,
+		GC:      p.gc,
+	}
+}
+
+// writePasses writes the single JSON document of a -format=json run of
+// mode, which runs several passes, nesting the summary of each.
+func writePasses(mode string, passes []passResult) error {
+	doc := struct {
+		Mode   string      `json:"mode"`
+		Passes []sweepPass `json:"passes"`
+	}{Mode: mode, Passes: []sweepPass{}}
+	for _, p := range passes {
+		doc.Passes = append(doc.Passes, p.summary())
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// Compare runs the benchmark once with arenas and once with the regular heap,
+// resetting GC state between the passes, and prints a delta summary. As for
+// Sweep, -format=json prints a single document nesting the passes, csv the
+// rows of the passes under one header, and bench only the passes' own
+// output. It returns the first error from bintree.Run.
+func Compare(cfg bintree.Config) error {
+	names := strings.Split(*compareOrder, ",")
+	if len(names) != 2 || names[0] == names[1] {
+		return usageError{errors.New("-compareorder must be arena,heap or heap,arena")}
+	}
+	for _, name := range names {
+		if name != "arena" && name != "heap" {
+			return usageError{fmt.Errorf("unknown -compareorder pass %q", name)}
+		}
+	}
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	if cfg.Format == "text" {
+		fmt.Fprintf(out, "pass order: %s (the first pass also warms the page cache)\n", strings.Join(names, ", "))
+	}
+
+	results := make(map[string]passResult, len(names))
+	for i, name := range names {
+		cfg.Alloc = name
+		cfg.Label = name
+		settleGC()
+		p, err := runPass(cfg)
+		if csvOut {
+			if err := p.renderCSV(i); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		results[name] = p
+	}
+
+	if jsonOut {
+		return writePasses("compare", []passResult{results[names[0]], results[names[1]]})
+	}
+	if cfg.Format != "text" {
+		return nil
+	}
+
+	a, h := results["arena"], results["heap"]
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-6s %12s %14s %18s %6s %12s %12s\n", "pass", "wall", "nodes/sec", "peak HeapInuse MB", "GCs", "total pause", "max pause")
+	for _, name := range names {
+		p := results[name]
+		fmt.Fprintf(out, "%-6s %12v %14.0f %18.1f %6d %12v %12v\n",
+			p.name,
+			p.elapsed.Round(time.Millisecond),
+			p.nodesPerSec(),
+			float64(p.peakHeapInuse)/(1<<20),
+			p.numGC,
+			p.pauseTotal.Round(time.Microsecond),
+			p.maxPause.Round(time.Microsecond))
+	}
+	fmt.Fprintf(out, "%-6s %11.1f%% %13.1f%% %17.1f%% %5.1f%%\n",
+		"delta",
+		percentDelta(float64(a.elapsed), float64(h.elapsed)),
+		percentDelta(a.nodesPerSec(), h.nodesPerSec()),
+		percentDelta(float64(a.peakHeapInuse), float64(h.peakHeapInuse)),
+		percentDelta(float64(a.numGC), float64(h.numGC)))
+	fmt.Fprintln(out, "(delta is heap relative to arena)")
+	comparePauses(out, names, results)
+	compareFrag(out, names, results)
+	return nil
+}
+
+// comparePauses writes the GC pause distributions of the -compare passes to
+// w, one line per pass.
+func comparePauses(w io.Writer, names []string, passes map[string]passResult) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "gc pauses")
+	for _, name := range names {
+		h := passes[name].gc.Pauses
+		if h == nil {
+			fmt.Fprintf(w, "%-6s not available\n", name)
+			continue
+		}
+		fmt.Fprintf(w, "%-6s %s\n", name, h)
+	}
+}
+
+// compareFrag writes the heap snapshots of the -compare passes to w side by
+// side, matched by name in the order of the first pass: the memory the
+// runtime retained unused and the memory it had released to the OS.
+func compareFrag(w io.Writer, names []string, passes map[string]passResult) {
+	snapshots := make([]map[string]bintree.HeapSnapshot, len(names))
+	for i, name := range names {
+		snapshots[i] = make(map[string]bintree.HeapSnapshot)
+		for _, s := range passes[name].gc.Heap {
+			snapshots[i][s.Name] = s
+		}
+	}
+	mb := func(b uint64) float64 { return float64(b) / (1 << 20) }
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-14s", "fragmentation")
+	for _, name := range names {
+		fmt.Fprintf(w, " %18s %18s", name+" retained MB", name+" released MB")
+	}
+	fmt.Fprintln(w)
+	for _, first := range passes[names[0]].gc.Heap {
+		fmt.Fprintf(w, "%-14s", first.Name)
+		for i := range names {
+			s, ok := snapshots[i][first.Name]
+			if !ok {
+				fmt.Fprintf(w, " %18s %18s", "-", "-")
+				continue
+			}
+			fmt.Fprintf(w, " %18.1f %18.1f", mb(s.Retained()), mb(s.HeapReleased))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// CompareBuilds runs the benchmark once for each of bintree.BuildModes,
+// resetting GC state between the passes, and prints the time each pass took
+// per depth. As for Sweep, -format=json prints a single document nesting the
+// passes, csv the rows of the passes under one header, and bench only the
+// passes' own output. It returns the first error from bintree.Run.
+func CompareBuilds(cfg bintree.Config) error {
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	modes := bintree.BuildModes
+	passes := make([]passResult, len(modes))
+	for i, mode := range modes {
+		cfg.Build = mode
+		cfg.Label = mode
+		settleGC()
+		p, err := runPass(cfg)
+		if csvOut {
+			if err := p.renderCSV(i); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		passes[i] = p
+	}
+	if jsonOut {
+		return writePasses("comparebuild", passes)
+	}
+	if cfg.Format != "text" {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-16s", "ms")
+	for _, mode := range modes {
+		fmt.Fprintf(out, " %12s", mode)
+	}
+	fmt.Fprintf(out, " %12s\n", "delta")
+	// Match the results by kind and depth, as a canceled pass may be
+	// missing some.
+	type key struct {
+		kind  string
+		depth int
+	}
+	byKey := make([]map[key]bintree.Result, len(passes))
+	for i, p := range passes {
+		byKey[i] = make(map[key]bintree.Result, len(p.results))
+		for _, r := range p.results {
+			byKey[i][key{r.Kind, r.Depth}] = r
+		}
+	}
+	for _, r := range passes[0].results {
+		name := fmt.Sprintf("depth %d", r.Depth)
+		switch r.Kind {
+		case bintree.KindStretch:
+			name = "stretch"
+		case bintree.KindLongLived:
+			name = "long lived"
+		}
+		k := key{r.Kind, r.Depth}
+		fmt.Fprintf(out, "%-16s", name)
+		for i := range passes {
+			if pr, ok := byKey[i][k]; ok {
+				fmt.Fprintf(out, " %12.1f", float64(pr.Elapsed)/float64(time.Millisecond))
+			} else {
+				fmt.Fprintf(out, " %12s", "-")
+			}
+		}
+		last, ok := byKey[len(passes)-1][k]
+		if !ok {
+			fmt.Fprintf(out, " %12s\n", "-")
+			continue
+		}
+		fmt.Fprintf(out, " %11.1f%%\n", percentDelta(float64(r.Elapsed), float64(last.Elapsed)))
+	}
+	fmt.Fprintf(out, "%-16s", "wall")
+	for _, p := range passes {
+		fmt.Fprintf(out, " %12.1f", float64(p.elapsed)/float64(time.Millisecond))
+	}
+	fmt.Fprintf(out, " %11.1f%%\n", percentDelta(float64(passes[0].elapsed), float64(passes[len(passes)-1].elapsed)))
+	fmt.Fprintf(out, "(delta is %s relative to %s)\n", modes[len(modes)-1], modes[0])
+	return nil
+}
+
+// payloadPasses are the payloads compared by -comparepayload. Both are 8
+// bytes, so the passes allocate the same number of arena bytes, and only the
+// second gives the GC pointers to trace out of the arenas.
+var payloadPasses = []string{"int64", "*int64"}
+
+// ComparePayloads runs the benchmark once with each of payloadPasses,
+// resetting GC state between the passes, and prints their GC counts, pause
+// and CPU times. As for Sweep, -format=json prints a single document nesting
+// the passes, csv the rows of the passes under one header, and bench only
+// the passes' own output. It returns the first error from bintree.Run.
+func ComparePayloads(cfg bintree.Config) error {
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	passes := make([]passResult, len(payloadPasses))
+	for i, payload := range payloadPasses {
+		cfg.Payload = payload
+		cfg.Label = payload
+		settleGC()
+		p, err := runPass(cfg)
+		if csvOut {
+			if err := p.renderCSV(i); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		passes[i] = p
+	}
+	if jsonOut {
+		return writePasses("comparepayload", passes)
+	}
+	if cfg.Format != "text" {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-8s %12s %14s %12s %6s %12s %12s %12s\n",
+		"payload", "wall", "nodes/sec", "arena MB", "GCs", "total pause", "max pause", "gc cpu")
+	for _, p := range passes {
+		fmt.Fprintf(out, "%-8s %12v %14.0f %12.1f %6d %12v %12v %12v\n",
+			p.name,
+			p.elapsed.Round(time.Millisecond),
+			p.nodesPerSec(),
+			float64(bintree.SumResults(p.results).Bytes)/(1<<20),
+			p.numGC,
+			p.pauseTotal.Round(time.Microsecond),
+			p.maxPause.Round(time.Microsecond),
+			p.gc.GCCPU.Round(time.Microsecond))
+	}
+	base, ptr := passes[0], passes[1]
+	fmt.Fprintf(out, "%-8s %11.1f%% %13.1f%% %12s %5.1f%% %11.1f%% %11.1f%% %11.1f%%\n",
+		"delta",
+		percentDelta(float64(base.elapsed), float64(ptr.elapsed)),
+		percentDelta(base.nodesPerSec(), ptr.nodesPerSec()),
+		"",
+		percentDelta(float64(base.numGC), float64(ptr.numGC)),
+		percentDelta(float64(base.pauseTotal), float64(ptr.pauseTotal)),
+		percentDelta(float64(base.maxPause), float64(ptr.maxPause)),
+		percentDelta(float64(base.gc.GCCPU), float64(ptr.gc.GCCPU)))
+	fmt.Fprintf(out, "(delta is %s relative to %s)\n", payloadPasses[1], payloadPasses[0])
+	return nil
+}
+
+// runPass runs the benchmark once, labeling its output with cfg.Label. If
+// the run fails with results, such as the partial ones of a canceled run,
+// they are returned along with the error.
+func runPass(cfg bintree.Config) (passResult, error) {
+	// Apply -gcpercent to the pass only, restoring the previous value for
+	// whatever runs between the passes.
+	defer debug.SetGCPercent(debug.SetGCPercent(*gcPercent))
+
+	res, err := run(cfg)
+	if res == nil {
+		return passResult{}, err
+	}
+	results, gc := res.All(), res.GC
+	return passResult{
+		name: cfg.Label,
+		// The run itself, without rendering its results.
+		elapsed:       res.Totals.Elapsed,
+		nodes:         bintree.TotalNodes(results),
+		peakHeapInuse: gc.PeakHeapInuse,
+		numGC:         gc.NumGC,
+		pauseTotal:    gc.PauseTotal,
+		maxPause:      gc.MaxPause,
+		results:       results,
+		gc:            gc,
+		res:           res,
+	}, err
+}
+
+// settleGC forces a collection and gives the runtime a moment to settle,
+// so one pass's garbage does not bleed into the next.
+func settleGC() {
+	runtime.GC()
+	time.Sleep(100 * time.Millisecond)
+}
+
+// percentDelta returns the percentage change from base to v.
+func percentDelta(base, v float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (v - base) / base * 100
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var count = flag.Int("count", 1, "run the whole benchmark `n` times, printing the complete output of each run "+
+	"labeled run 1/n and so on, or, with -format=bench, each benchmark line n times for benchstat; "+
+	"the profiles span all n runs")
+
+// Count runs the benchmark -count times with identical configuration, with a
+// GC between runs so they are independent. Every run starts with fresh
+// arenas and GC baselines, and prints its own output. In the bench format
+// the runs are left unlabeled, so benchstat sees every run as a sample of
+// the same benchmarks. In the csv format the runs share one header row, and
+// with -format=json they are a single document nesting an array of them.
+// It returns the first error from bintree.Run.
+func Count(cfg bintree.Config) error {
+	// Count renders the csv and json runs itself, unless -quiet prints
+	// only their summaries.
+	format := ""
+	if !cfg.Quiet && (cfg.Format == "csv" || cfg.Format == "json") {
+		format = cfg.Format
+		cfg.Quiet = true
+	}
+	runs := make([]*bintree.Results, 0, *count)
+	for i := 1; i <= *count; i++ {
+		settleGC()
+		if cfg.Format != "bench" {
+			cfg.Label = fmt.Sprintf("run %d/%d", i, *count)
+		}
+		start := time.Now()
+		res, err := run(cfg)
+		if *quiet && res != nil {
+			printSummary(res.All(), res.GC, time.Since(start))
+		}
+		if format == "csv" && res != nil {
+			if err := res.RenderCSV(out, i == 1); err != nil {
+				return err
+			}
+		}
+		if res != nil {
+			runs = append(runs, res)
+		}
+		if err != nil {
+			if format == "json" {
+				// Write the runs done so far, as a single run writes
+				// its partial results.
+				writeCountRuns(runs)
+			}
+			return err
+		}
+	}
+	if format == "json" {
+		return writeCountRuns(runs)
+	}
+	return nil
+}
+
+// writeCountRuns writes the single JSON document of a -count run.
+func writeCountRuns(runs []*bintree.Results) error {
+	doc := struct {
+		Count int                `json:"count"`
+		Runs  []*bintree.Results `json:"runs"`
+	}{Count: *count, Runs: runs}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var cpus sizeList
+
+func init() {
+	flag.Var(&cpus, "cpus", "comma-separated GOMAXPROCS `values` such as 1,2,4,8 to run the benchmark with in turn, "+
+		"printing a comparison")
+}
+
+// CompareCPUs runs the benchmark once for each -cpus value of GOMAXPROCS,
+// resetting GC state between the passes, and prints the wall time and node
+// rate of each. As for Sweep, -format=json prints a single document nesting
+// the passes, csv the rows of the passes under one header, and bench only
+// the passes' own output.
+// It restores GOMAXPROCS and returns the first error from bintree.Run.
+func CompareCPUs(cfg bintree.Config) error {
+	for _, n := range cpus {
+		if n < 1 {
+			return usageError{fmt.Errorf("-cpus values must be at least 1, not %d", n)}
+		}
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+
+	passes := make([]passResult, len(cpus))
+	for i, n := range cpus {
+		runtime.GOMAXPROCS(n)
+		cfg.Label = fmt.Sprintf("cpus=%d", n)
+		settleGC()
+		p, err := runPass(cfg)
+		if csvOut {
+			if err := p.renderCSV(i); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		passes[i] = p
+	}
+
+	if jsonOut {
+		return writePasses("cpus", passes)
+	}
+	if cfg.Format != "text" {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-6s %12s %14s %9s %18s %6s\n", "cpus", "wall", "nodes/sec", "speedup", "peak HeapInuse MB", "GCs")
+	for i, p := range passes {
+		fmt.Fprintf(out, "%-6d %12v %14.0f %8.2fx %18.1f %6d\n",
+			cpus[i],
+			p.elapsed.Round(time.Millisecond),
+			p.nodesPerSec(),
+			p.nodesPerSec()/passes[0].nodesPerSec(),
+			float64(p.peakHeapInuse)/(1<<20),
+			p.numGC)
+	}
+	fmt.Fprintf(out, "(speedup is the node rate relative to %d cpus)\n", cpus[0])
+	return nil
+}
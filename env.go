@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables that set the
+// defaults of the flags: BTREE_ followed by the flag name in upper case with
+// dashes as underscores, such as BTREE_MINALLOC or BTREE_MAXDEPTH_ALLOWED.
+const envPrefix = "BTREE_"
+
+// envKey returns the environment variable that sets the default of the flag
+// name.
+func envKey(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// envSetting is a flag default taken from the environment.
+type envSetting struct {
+	name, key, value string
+}
+
+// applyEnv sets the value of every flag of fs whose environment variable
+// lookup finds, before the command line is parsed, so that the flags given
+// on the command line still take precedence. The flags are not marked as
+// set, so they count as defaults for the checks of flags set together. It
+// returns the settings applied, in flag name order, or an error naming the
+// variable whose value is invalid for its flag.
+func applyEnv(fs *flag.FlagSet, lookup func(key string) (string, bool)) ([]envSetting, error) {
+	var settings []envSetting
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		key := envKey(f.Name)
+		value, ok := lookup(key)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, key, setErr)
+			return
+		}
+		settings = append(settings, envSetting{name: f.Name, key: key, value: value})
+	})
+	return settings, err
+}
+
+// envInEffect returns the settings that the command line parsed by fs did not
+// override, as KEY=value, for the run metadata.
+func envInEffect(fs *flag.FlagSet, settings []envSetting) []string {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var env []string
+	for _, s := range settings {
+		if !set[s.name] {
+			env = append(env, s.key+"="+s.value)
+		}
+	}
+	return env
+}
+
+// dropEnv returns env without the setting of the flag name.
+func dropEnv(env []string, name string) []string {
+	prefix := envKey(name) + "="
+	kept := env[:0:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, prefix) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
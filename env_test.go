@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *int, *float64, *string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		depth := fs.Int("depth", 21, "")
+		minAlloc := fs.Float64("minalloc", 1, "")
+		format := fs.String("format", "text", "")
+		fs.Int("maxdepth-allowed", 30, "")
+		return fs, depth, minAlloc, format
+	}
+	env := map[string]string{
+		"BTREE_DEPTH":            "12",
+		"BTREE_MINALLOC":         "4",
+		"BTREE_MAXDEPTH_ALLOWED": "40",
+		"BTREE_UNKNOWN":          "1",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	fs, depth, minAlloc, format := newFlags()
+	settings, err := applyEnv(fs, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The command line wins over the environment.
+	if err := fs.Parse([]string{"-depth=10"}); err != nil {
+		t.Fatal(err)
+	}
+	if *depth != 10 || *minAlloc != 4 || *format != "text" {
+		t.Errorf("depth %d minalloc %g format %q, want 10 from the command line, 4 from the environment "+
+			"and the default text", *depth, *minAlloc, *format)
+	}
+	want := []string{"BTREE_MAXDEPTH_ALLOWED=40", "BTREE_MINALLOC=4"}
+	if got := envInEffect(fs, settings); !reflect.DeepEqual(got, want) {
+		t.Errorf("env in effect %q, want %q", got, want)
+	}
+	// Defaults from the environment do not count as set.
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "depth" {
+			t.Errorf("flag %s is set, want only depth", f.Name)
+		}
+	})
+
+	env["BTREE_DEPTH"] = "deep"
+	fs, _, _, _ = newFlags()
+	if _, err := applyEnv(fs, lookup); err == nil {
+		t.Error("applied BTREE_DEPTH=deep, want an error")
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/vmihailenco/golang-memory-arena
 
-go 1.20
+go 1.21
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var httpAddr = flag.String("http", "", "serve net/http/pprof and a /status progress endpoint on `addr` during the run")
+
+// progress is published on /status when -http is set, and printed to
+// stderr with -progress.
+var progress *bintree.Progress
+
+// startHTTP serves the net/http/pprof handlers, /status, which reports
+// progress as JSON, and /metrics, in the Prometheus text format, on -http. The returned stop function shuts the server
+// down gracefully. It is a no-op if -http is not set.
+func startHTTP() (stop func() error, err error) {
+	if *httpAddr == "" {
+		return func() error { return nil }, nil
+	}
+	ln, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(progress.Status())
+	})
+	http.HandleFunc("/metrics", serveMetrics)
+	srv := &http.Server{}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			slog.Error("http server failed", "err", err)
+		}
+	}()
+	slog.Info("serving pprof, /status and /metrics", "url", "http://"+ln.Addr().String())
+
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}, nil
+}
+
+// serveMetrics writes the progress totals and MemStats, read when scraped, in
+// the Prometheus text exposition format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	totals := progress.Totals()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, typ, help string
+		value           int64
+	}{
+		{"trees_total", "counter", "Trees built.", totals.Trees},
+		{"nodes_total", "counter", "Tree nodes allocated.", totals.Nodes},
+		{"arenas_created_total", "counter", "Arenas created.", totals.ArenasCreated},
+		{"arenas_freed_total", "counter", "Arenas freed.", totals.ArenasFreed},
+		{"heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.", int64(ms.HeapInuse)},
+		{"gc_count", "counter", "Completed GC cycles.", int64(ms.NumGC)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.typ, m.name, m.value)
+	}
+}
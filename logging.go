@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	logLevel  = flag.String("loglevel", "info", "lowest `level` of the diagnostics logged to stderr: debug, info, warn, error")
+	logFormat = flag.String("logformat", "text", "`format` of the diagnostics logged to stderr: text, json; "+
+		"the benchmark results are written to stdout either way")
+)
+
+// setupLogging makes the -loglevel and -logformat logger the default slog
+// logger, which the log package then writes through as well.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -loglevel %q, must be one of debug, info, warn, error", *logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(*logFormat) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -logformat %q, must be text or json", *logFormat)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
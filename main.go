@@ -4,10 +4,44 @@
 // Modifications include:
 //  * adding arenas support
 //  * -minalloc flag controls how frequently each worker goroutine calls Free
-//  * -single flag creates 1 tree in 1 goroutine
-//  * -cpuprofile and -memprofile flags for pprof
+//  * -single flag creates 1 tree in 1 goroutine, -singledepth and -singleiters many trees of any depth
+//  * -noarena flag allocates from the regular heap for a baseline run
+//  * -alloc flag selects a pluggable allocation strategy
+//  * -heapfrac flag allocates a fraction of the nodes on the heap, mixed with the arena ones
+//  * -rootindex flag keeps the roots of the arena trees in a heap slice until their arena is freed
+//  * -freemode flag chooses when arenas are freed: after -minalloc, at the end, never, or every -freeevery trees
+//  * -freecount flag frees each arena after a fixed number of trees instead of after -minalloc
+//  * -compare flag runs an arena pass and a heap pass and summarizes the deltas
+//  * -leakcheck flag checks with finalizers that the heap runs retain none of their trees
+//  * -comparepayload flag compares the GC cost of arena nodes pointing at heap objects with pointer-free ones
+//  * -build flag builds the trees recursively or with an explicit stack, -comparebuild compares the two
+//  * -layout flag allocates the tree nodes in post-order or level order
+//  * -cpus flag runs the benchmark with several GOMAXPROCS values and compares them
+//  * -scaling flag builds a fixed number of trees with 1, 2, 4 ... NumCPU workers and compares them
+//  * -autotune flag picks -minalloc from short calibration runs, within a -maxmem peak RSS limit
+//  * -compat flag prints exactly the output of the reference program
+//  * -count flag runs the whole benchmark several times, for benchstat
+//  * -depthsweep and -minallocsweep flags run the benchmark with several depths or minalloc values
+//  * -config flag runs a matrix of depths, minalloc values, allocators and workloads from a JSON file
+//  * -cpuprofile, -memprofile, -blockprofile, -mutexprofile and -goroutineprofile flags for pprof
+//  * -fanout flag splits the trees of each depth across several goroutines
+//  * -lockthreads flag locks each depth worker goroutine to its OS thread
+//  * -v flag logs the lifecycle of every arena to stderr
+//  * -baseline flag compares the run depth by depth against saved JSON results
+//  * -out flag also writes the results of every run as JSON to a file
+//  * -progress flag logs the progress of the run every few seconds
+//  * BTREE_<FLAG> environment variables, such as BTREE_MINALLOC, set the flag defaults
+//  * -loglevel and -logformat flags select the level and text or JSON format of the diagnostics on stderr
+//  * -http flag serves net/http/pprof and the progress of the run
+//  * -micro flag times single operations, such as creating an arena, outside the tree workload
+//  * -demonstrate-uaf flag (dangerous) checks that a use after free of an arena faults
+//  * -dryrun flag prints the estimated memory of the run, which -maxmem also limits
+//  * -depth flag sets the binary tree depth, also accepted as the argument, up to -maxdepth-allowed
 //  * default to binary tree depth of 21 if not specified via command line
 //  * slightly modified output
+//  * bench, sweep, micro and compare commands each accept only their own flags; see the help command
+//  * exit status 1 if the run failed, such as a tree with the wrong node count, 2 for bad flags, 3 after -timeout
+//  * the tree and benchmark logic live in the importable bintree package
 //
 // License is 3-Clause BSD:
 //   https://benchmarksgame-team.pages.debian.net/benchmarksgame/license.html
@@ -55,221 +89,745 @@
 package main
 
 import (
-	"arena"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
 	"os"
+	"os/signal"
 	"runtime"
-	"runtime/pprof"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
 )
 
+var defaults = bintree.DefaultConfig()
+
 // minalloc flag controls how frequently each worker goroutine calls Free
-var minAllocMB = flag.Float64("minalloc", 1, "upon completing a tree, a worker goroutine "+
+var minAllocMB = flag.Float64("minalloc", defaults.MinAllocMB, "upon completing a tree, a worker goroutine "+
 	"reuses its arena unless the arena has completed more than minalloc `MB` of allocations")
-var single = flag.Bool("single", false, "allocate one tree in a single goroutine")
+var minDepth = flag.Int("mindepth", defaults.MinDepth, "minimum `depth` of the short-lived trees (at least 1)")
+var (
+	depth = flag.Int("depth", defaults.MaxDepth, "binary tree `depth`: of the long-lived tree and the deepest short-lived "+
+		"trees; overrides the depth given as the argument")
+	maxDepthAllowed = flag.Int("maxdepth-allowed", 30, "largest `depth` accepted by -depth, the depth argument "+
+		"and -depthsweep")
+)
+
+var dryRun = flag.Bool("dryrun", false, "print the estimated memory of the run and exit without running")
+
+// largeRunBytes is the estimated memory above which the run prints the
+// estimate before starting, as it does with -maxmem.
+const largeRunBytes = 4 << 30
 
 var (
-	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to `file`")
-	memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+	iterations = flag.Int("iterations", 0, "if positive, build `n` trees at every depth instead of 1<<(maxdepth-depth+mindepth)")
+	iterScale  = flag.Float64("iterscale", defaults.IterScale, "multiply the number of trees built at each depth by `factor`")
+	benchTime  = flag.Duration("benchtime", 0, "if positive, build trees at every depth for `duration` "+
+		"instead of a fixed number of iterations")
+	warmup     = flag.Int("warmup", 0, "build `n` unreported trees at every depth before the measured ones")
+	warmupTime = flag.Duration("warmuptime", 0, "build unreported trees at every depth for `duration` before the measured ones")
+)
+var fanout = flag.Int("fanout", 0, "split the trees of each depth, or those of -singleiters, across `n` goroutines, "+
+	"each with its own arena, merging their results")
+var verbose = flag.Bool("v", false, "log every arena created, freed or leaked by the depth workers and the stretch "+
+	"and long-lived trees at the end of the run, with the bytes and trees allocated from it and how long it lived")
+var lockThreads = flag.Bool("lockthreads", false, "lock each depth worker goroutine to its OS thread for its lifetime "+
+	"(changes the scheduling being benchmarked)")
+var (
+	freeMode = flag.String("freemode", defaults.FreeMode, "when the per-depth workers free their arenas: "+
+		"mb (after -minalloc), end (when the worker is done), never (leave them for the GC), "+
+		"or interval (every -freeevery trees)")
+	freeEvery = flag.Int("freeevery", 0, "free the arena every `n` trees with -freemode=interval")
+	freeCount = flag.Int("freecount", 0, "if positive, free each arena after `n` trees instead of after -minalloc "+
+		"(same as -freemode=interval -freeevery=n)")
+)
+var workers = flag.Int("workers", 0, "build the per-depth trees with a pool of `n` worker goroutines "+
+	"(0 means one goroutine per depth)")
+var (
+	allocName = flag.String("alloc", defaults.Alloc, "tree node allocation `strategy`: "+strings.Join(bintree.AllocatorNames(), ", ")+
+		", chunked (same as slab, which bump-allocates the nodes from arena chunks of -chunknodes nodes), "+
+		"or all with -micro=alloc")
+	arenaPool = flag.Bool("arenapool", false, "share the per-depth workers' arenas through a pool that frees each arena "+
+		"once it has allocated more than -minalloc")
+	chunkNodes = flag.Int("chunknodes", defaults.ChunkNodes, "number of `nodes` per chunk for -alloc=slab and -alloc=freelist")
 )
 
-type Tree struct {
-	Left  *Tree
-	Right *Tree
+func init() {
+	flag.IntVar(chunkNodes, "chunk", defaults.ChunkNodes, "same as -chunknodes")
 }
 
-// Count the nodes in the given complete binary tree.
-func (t *Tree) Count() int {
-	// Only test the Left node (this binary tree is expected to be complete).
-	if t.Left == nil {
-		return 1
-	}
-	return 1 + t.Right.Count() + t.Left.Count()
+var heapFrac = flag.Float64("heapfrac", 0, "allocate each node on the heap instead of the arena with probability `f` "+
+	"(0 to 1), drawn from -seed, so arena and heap nodes point at each other")
+var leakCheck = flag.Bool("leakcheck", false, "with the heap allocator, set finalizers on 1 in 10000 trees of each depth "+
+	"and report any not collected by the end of the run")
+var rootIndex = flag.Bool("rootindex", false, "have each depth worker keep the roots of its trees in a heap slice "+
+	"until their arena is freed, so the GC scans heap pointers into arenas")
+var padding = flag.Int("padding", 0, "grow each tree node by embedding an array of `n` bytes "+
+	"(one of "+fmt.Sprint(bintree.Paddings)+")")
+var payload = flag.String("payload", defaults.Payload, "tree node payload `type`: "+strings.Join(bintree.Payloads, ", ")+
+	"; the string and *int64 payloads hold pointers the GC must scan, parent points each node at its parent")
+var (
+	build  = flag.String("build", defaults.Build, "how complete trees are `built`: "+strings.Join(bintree.BuildModes, ", "))
+	layout = flag.String("layout", defaults.Layout, "allocation `order` of the nodes of complete trees: "+
+		strings.Join(bintree.Layouts, ", "))
+)
+var (
+	workload = flag.String("workload", defaults.Workload, "per-depth `workload`: "+strings.Join(bintree.Workloads, ", "))
+	seed     = flag.Int64("seed", defaults.Seed, "`seed` for randomized workloads and -heapfrac")
+	listLen  = flag.Int("listlen", 0, "`length` of the lists built by -workload=list "+
+		"(0 means as many nodes as a complete tree of each depth)")
+	mutateLevel = flag.Int("mutatelevel", 0, "`level` below the root of the subtrees replaced by -workload=mutate "+
+		"(0 means half the depth)")
+	bstKeys = flag.Int("bstkeys", 0, "`keys` inserted into each tree by -workload=bst "+
+		"(0 means as many as a complete tree of each depth has nodes)")
+	branch     = flag.Int("branch", defaults.Branch, "`children` per node of the trees built by -workload=nary")
+	sliceSizes = sizeList(defaults.SliceSizes)
+)
+
+func init() {
+	flag.Var(&sliceSizes, "slicesize", "comma-separated buffer `sizes` in bytes for -workload=bytes, used in turn")
 }
 
-// Create a complete binary tree of `depth` and return it as a pointer.
-func NewTree(depth int, a *arena.Arena) *Tree {
-	// thepudds: alloc via an arena if we have one.
-	if depth > 0 {
-		// thepudds: note that for this particular benchmark, it is faster to create the
-		// left and right sub-trees before allocating our own tree node.
-		// Otherwise, we could eliminate a couple of lines here.
-		left := NewTree(depth-1, a)
-		right := NewTree(depth-1, a)
-		treePtr := allocTreeNode(a)
-		treePtr.Left = left
-		treePtr.Right = right
-		return treePtr
-	} else {
-		return allocTreeNode(a)
+var (
+	ballastMB   = flag.Int("ballast", 0, "retain a live heap ballast of `MB` during the run")
+	ballastType = flag.String("ballasttype", defaults.BallastType, "ballast `type`: "+strings.Join(bintree.BallastTypes, ", ")+
+		"; only a pointers ballast makes the GC scan more")
+)
+var cloneLongLived = flag.Bool("clonelonglived", false, "deep-copy the long-lived tree out of its arena "+
+	"and free the arena as soon as the tree is built")
+var noValidate = flag.Bool("novalidate", false, "skip checking the node count of every complete tree")
+var compact = flag.Bool("compact", false, "have each worker copy its most recent tree into a survivor arena "+
+	"before it frees its scratch arena")
+var (
+	single      = flag.Bool("single", false, "allocate one tree in a single goroutine")
+	singleDepth = flag.Int("singledepth", 0, "`depth` of the trees built by -single (0 means the stretch tree depth); implies -single")
+	singleIters = flag.Int("singleiters", defaults.SingleIters, "number of `trees` built one after the other by -single; implies -single")
+)
+var serialPhases = flag.Bool("serialphases", false, "build the stretch tree, the long-lived tree and the per-depth trees "+
+	"one after the other, to tell their memory use apart")
+var noArena = flag.Bool("noarena", false, "allocate tree nodes from the regular heap instead of arenas (same as -alloc=heap)")
+
+var gcPercent = flag.Int("gcpercent", bintree.GCPercent(), "set the GC target `percentage` (see debug.SetGCPercent); "+
+	"-1 disables the GC")
+
+var memLimit byteSize
+
+func init() {
+	flag.Var(&memLimit, "memlimit", "set a soft memory `limit` such as 512MiB during the run (see debug.SetMemoryLimit)")
+}
+
+var timeout = flag.Duration("timeout", 0, "stop the run after `duration`, printing the results completed so far "+
+	"and exiting with status 3")
+
+var (
+	format = flag.String("format", defaults.Format, "output `format`: text, json, csv, bench (go test benchmark format, for benchstat), "+
+		"or compat (see -compat)")
+	outFile = flag.String("o", "", "write results to `file` instead of stdout")
+	quiet   = flag.Bool("quiet", false, "print only a one-line summary of the run instead of the per-depth output")
+)
+
+var compat = flag.Bool("compat", false, "print exactly the output of the Benchmarks Game reference program, "+
+	"with its check values, and none of the arena columns (same as -format=compat)")
+
+var resultsFile = flag.String("out", "", "also write the results of every run as JSON, with the run metadata and "+
+	"GC totals, to `file`")
+
+// out is where results are written; main points it at the -o file if set.
+var out io.Writer = os.Stdout
+
+// resultsOut is the -out file, if set.
+var resultsOut io.Writer
+
+// errFailed is returned by benchmark, wrapping the error if there is one,
+// when it has already logged why the run failed, so main only sets the exit
+// status.
+var errFailed = errors.New("run failed")
+
+// The exit statuses of a failed run.
+const (
+	exitFailed  = 1 // the run failed, such as a tree with the wrong node count
+	exitUsage   = 2 // the flags or arguments are invalid
+	exitTimeout = 3 // -timeout stopped the run
+)
+
+// usageError is an error in the flags or arguments, as opposed to a run
+// that failed.
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+// exitStatus returns the exit status for err, returned by a command.
+func exitStatus(err error) int {
+	switch {
+	case errors.As(err, new(usageError)):
+		return exitUsage
+	case errors.Is(err, bintree.ErrTimeout):
+		return exitTimeout
 	}
+	return exitFailed
 }
 
-// Allocate an empty tree node, using an arena if provided.
-func allocTreeNode(a *arena.Arena) *Tree {
-	if a != nil {
-		return arena.New[Tree](a)
-	} else {
-		return &Tree{}
+// envFlags lists the flag defaults taken from the environment that the
+// command line did not override, as KEY=value.
+var envFlags []string
+
+func main() {
+	// A command parses only its own flags, and replaces flag.CommandLine
+	// so the rest of the program finds them there.
+	args, cmd := os.Args[1:], benchmark
+	if len(args) > 0 && args[0] == "help" {
+		if err := help(os.Stdout, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		return
+	}
+	if len(args) > 0 {
+		if c := findCommand(args[0]); c != nil {
+			flag.CommandLine = c.flagSet()
+			args, cmd = args[1:], c.run
+		}
+	}
+	flag.Usage = usage
+
+	settings, err := applyEnv(flag.CommandLine, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	flag.CommandLine.Parse(args)
+	envFlags = envInEffect(flag.CommandLine, settings)
+	if flag.NArg() > 0 {
+		// The depth argument overrides BTREE_DEPTH as -depth does.
+		envFlags = dropEnv(envFlags, "depth")
+	}
+	// Set the rate before allocating anything else, so it applies to every
+	// sampled allocation.
+	runtime.MemProfileRate = *memprofilerate
+
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	// benchmark returns rather than exits on errors, so that its deferred
+	// profiles and files are written first.
+	if err := cmd(); err != nil {
+		if !errors.Is(err, errFailed) {
+			slog.Error(err.Error())
+		}
+		os.Exit(exitStatus(err))
 	}
 }
 
-func Run(maxDepth int) {
-	var wg sync.WaitGroup
+// benchmark runs whatever the flags ask for, returning errFailed if it has
+// logged a failure itself.
+func benchmark() (err error) {
+	if *demonstrateUAF {
+		// Never combined with a benchmark run.
+		if !DemonstrateUAF() {
+			return errFailed
+		}
+		return nil
+	}
+	if *micro != "" {
+		// Like -demonstrate-uaf, never combined with a benchmark run.
+		return Micro()
+	}
 
-	// Set minDepth to 4 and maxDepth to the maximum of maxDepth and minDepth +2.
-	const minDepth = 4
-	if maxDepth < minDepth+2 {
-		maxDepth = minDepth + 2
+	n := *depth
+	if flag.NArg() > 0 && !isFlagSet("depth") {
+		n, err = strconv.Atoi(flag.Arg(0))
+		if err != nil {
+			return usageError{fmt.Errorf("must specify binary tree depth as integer: %w", err)}
+		}
+	}
+	for _, d := range append([]int{n}, depthSweep...) {
+		if d < 1 || d > *maxDepthAllowed {
+			return usageError{fmt.Errorf("depth %d must be between 1 and %d (see -maxdepth-allowed)", d, *maxDepthAllowed)}
+		}
 	}
 
-	// Create an indexed string buffer for outputing the result in order.
-	outCurr := 0
-	outSize := 3 + (maxDepth-minDepth)/2
-	outBuff := make([]string, outSize)
+	if *scaling {
+		if *compareBuild || *comparePayload || len(cpus) > 0 || len(depthSweep) > 0 || len(minAllocSweep) > 0 ||
+			*repeat > 1 || *count > 1 || *baselineFile != "" {
+			return usageError{errors.New("-scaling cannot be combined with -comparebuild, -comparepayload, -cpus, -depthsweep, " +
+				"-minallocsweep, -repeat, -count or -baseline")}
+		}
+		if isFlagSet("fanout") || isFlagSet("workers") || *single || *singleDepth > 0 || *singleIters > 1 {
+			return usageError{errors.New("-scaling sets the workers and trees of each pass and cannot be combined with -fanout, " +
+				"-workers, -single, -singledepth or -singleiters")}
+		}
+		if *scalingDepth < 1 || *scalingDepth > *maxDepthAllowed {
+			return usageError{fmt.Errorf("-scalingdepth %d must be between 1 and %d (see -maxdepth-allowed)", *scalingDepth, *maxDepthAllowed)}
+		}
+		if *scalingTrees < 1 {
+			return usageError{fmt.Errorf("-scalingtrees must be at least 1, not %d", *scalingTrees)}
+		}
+	}
+	if *allocName == "all" {
+		return usageError{errors.New("-alloc=all needs -micro=alloc")}
+	}
+	if *compare && (isFlagSet("alloc") || *noArena) {
+		return usageError{errors.New("-compare sets the allocator of each pass and cannot be combined with -alloc or -noarena")}
+	}
+	if *freeCount != 0 && (isFlagSet("minalloc") || isFlagSet("freemode") || isFlagSet("freeevery")) {
+		return usageError{errors.New("-freecount cannot be combined with -minalloc, -freemode or -freeevery")}
+	}
+	if *leakCheck && *allocName != "heap" && !*noArena && !*compare {
+		return usageError{errors.New("-leakcheck checks the heap allocator and needs -alloc=heap, -noarena or -compare")}
+	}
+	if *comparePayload && (isFlagSet("payload") || isFlagSet("padding")) {
+		return usageError{errors.New("-comparepayload sets the payload of each pass and cannot be combined with -payload or -padding")}
+	}
+	if *compat && (isFlagSet("format") && *format != "compat" || *quiet) {
+		return usageError{errors.New("-compat sets the output format and cannot be combined with -format or -quiet")}
+	}
+	if *count > 1 && (*compare || *compareBuild || *comparePayload || len(cpus) > 0 || len(depthSweep) > 0 ||
+		len(minAllocSweep) > 0 || *repeat > 1) {
+		return usageError{errors.New("-count repeats a single run and cannot be combined with -compare, -comparebuild, " +
+			"-comparepayload, -cpus, -depthsweep, -minallocsweep or -repeat")}
+	}
+	if *autotune && len(minAllocSweep) > 0 {
+		return usageError{errors.New("-autotune cannot be combined with -minallocsweep")}
+	}
+	cfg := config(n)
+	if *scaling {
+		cfg = scalingConfig(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return usageError{fmt.Errorf("invalid flags: %w", err)}
+	}
+	var matrix *matrixConfig
+	if *configFile != "" {
+		if *compare || *compareBuild || *comparePayload || len(cpus) > 0 || len(depthSweep) > 0 || len(minAllocSweep) > 0 ||
+			*repeat > 1 || *count > 1 || *scaling || *autotune || *baselineFile != "" {
+			return usageError{errors.New("-config runs its own matrix and cannot be combined with -compare, -comparebuild, " +
+				"-comparepayload, -cpus, -depthsweep, -minallocsweep, -repeat, -count, -scaling, -autotune or -baseline")}
+		}
+		// Report a bad matrix before anything runs.
+		if matrix, err = loadMatrix(*configFile); err != nil {
+			return err
+		}
+		runs, err := matrix.runs(cfg)
+		if err != nil {
+			return usageError{fmt.Errorf("invalid -config %s: %w", *configFile, err)}
+		}
+		for _, rc := range runs {
+			if est := rc.EstimateMemory(); maxMem > 0 && est.Total() > int64(maxMem) {
+				return fmt.Errorf("-config %s: run %s: %s", *configFile, rc.Label, overMaxMem(rc, est))
+			}
+		}
+	}
+	est := cfg.EstimateMemory()
+	switch {
+	case *dryRun:
+		fmt.Println(est)
+		return nil
+	case maxMem > 0 && est.Total() > int64(maxMem):
+		return errors.New(overMaxMem(cfg, est))
+	case maxMem > 0 || est.Total() > largeRunBytes:
+		slog.Info(est.String())
+	}
 
-	// Create binary tree of depth maxDepth+1, compute its Count and set the
-	// first position of the outputBuffer with its statistics.
-	wg.Add(1)
-	go func() {
-		// thepudds: create a single arena for this single (usually large) tree,
-		// freeing it when we are done with this tree.
-		stretchArena := arena.NewArena()
-		defer stretchArena.Free()
-
-		tree := NewTree(maxDepth+1, stretchArena)
-		nodes := tree.Count()
-		msg := fmt.Sprintf("   stretch tree of depth %-8d arenas: %-6d nodes: %-10d MB: %0.1f",
-			maxDepth+1,
-			1,
-			nodes,
-			float64(nodes*16)/(1<<20))
-
-		outBuff[0] = msg
-		wg.Done()
-	}()
-	if *single {
-		// thepudds: only do a single tree (with only one goroutine)
-		wg.Wait()
-		return
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return fmt.Errorf("could not create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if *resultsFile != "" {
+		// Like the profiles, fail before the run rather than after it.
+		f, err := os.Create(*resultsFile)
+		if err != nil {
+			return fmt.Errorf("could not create results file: %w", err)
+		}
+		defer f.Close()
+		resultsOut = f
 	}
 
-	// Create a long-lived binary tree of depth maxDepth. Its statistics will be
-	// handled later.
-	var longLivedTree *Tree
-	wg.Add(1)
-	// thepudds: also create a long-lived arena for this long-lived tree,
-	// freeing it when we are done with this function.
-	longLivedArena := arena.NewArena()
-	defer longLivedArena.Free()
+	var baseline *bintree.Results
+	if *baselineFile != "" {
+		if *compare || *compareBuild || *comparePayload || len(cpus) > 0 || len(depthSweep) > 0 || len(minAllocSweep) > 0 ||
+			*repeat > 1 || *count > 1 {
+			return usageError{errors.New("-baseline compares a single run and cannot be combined with -compare, -comparebuild, " +
+				"-comparepayload, -cpus, -depthsweep, -minallocsweep, -repeat or -count")}
+		}
+		// Read it now rather than find out it is unreadable after the run.
+		b, err := loadBaseline(*baselineFile)
+		if err != nil {
+			return fmt.Errorf("could not load baseline: %w", err)
+		}
+		baseline = b
+	}
 
-	go func() {
-		longLivedTree = NewTree(maxDepth, longLivedArena)
-		wg.Done()
-	}()
+	// The deferred stop functions report the first error if the run did
+	// not fail already, and log it otherwise.
+	keepErr := func(what string, stopErr error) {
+		switch {
+		case stopErr == nil:
+		case err == nil:
+			err = fmt.Errorf("%s: %w", what, stopErr)
+		default:
+			slog.Error(what, "err", stopErr)
+		}
+	}
+
+	// Deferred profiles are written even if the run panics.
+	stopProfiles, err := startProfiles()
+	if err != nil {
+		return err
+	}
+	defer func() { keepErr("could not write profiles", stopProfiles()) }()
+
+	stopMemStats, err := startMemStatsSampler()
+	if err != nil {
+		return fmt.Errorf("could not start MemStats sampler: %w", err)
+	}
+	defer func() { keepErr("could not write MemStats time series", stopMemStats()) }()
+
+	stopHeapSnapshots, err := startHeapSnapshots()
+	if err != nil {
+		return fmt.Errorf("could not start heap profile snapshots: %w", err)
+	}
+	defer func() { keepErr("could not write heap profile snapshot", stopHeapSnapshots()) }()
+
+	if *httpAddr != "" || *showProgress {
+		progress = new(bintree.Progress)
+		cfg.Progress = progress
+	}
+	stopHTTP, err := startHTTP()
+	if err != nil {
+		return fmt.Errorf("could not start http server: %w", err)
+	}
+	defer func() { keepErr("could not shut down http server", stopHTTP()) }()
+
+	stopProgress := startProgressReporter()
+	defer stopProgress()
+
+	stop := newRunStopper()
+	cfg.Cancel = stop.done
+	if *timeout > 0 {
+		// One deadline for every pass, which stops the pass it falls in
+		// and any after it.
+		cfg.Deadline = time.Now().Add(*timeout)
+	}
+
+	if !*compare && !*compareBuild && !*comparePayload && len(cpus) == 0 && len(depthSweep) == 0 && len(minAllocSweep) == 0 &&
+		!*scaling {
+		// The comparisons set it for each pass instead.
+		debug.SetGCPercent(*gcPercent)
+	}
+
+	if *autotune {
+		cfg.MinAllocMB, err = Autotune(cfg)
+	}
 
-	// Create a lot of binary trees, of depths ranging from minDepth to maxDepth,
-	// compute and tally up all their Count and record the statistics.
-	for depth := minDepth; depth <= maxDepth; depth += 2 {
-		iterations := 1 << (maxDepth - depth + minDepth)
-		outCurr++
-
-		wg.Add(1)
-		go func(depth, iterations, index int) {
-			// Create a binary tree of depth and accumulate total counter with its
-			// node count.
-
-			// thepudds: Also create an arena for the binary tree allocations for this goroutine.
-			// We reuse each arena until it has allocated more than minAllocMB.
-			treeArena := arena.NewArena()
-			arenaCount := 1
-			allocated := 0
-
-			nodes := 0
-			for i := 0; i < iterations; i++ {
-				if allocated > int(*minAllocMB*(1<<20)) {
-					treeArena.Free()
-					treeArena = arena.NewArena()
-					arenaCount++
-					allocated = 0
-				}
-				tree := NewTree(depth, treeArena)
-				newNodes := tree.Count()
-				nodes += newNodes
-				allocated += newNodes * 16
+	failed := false
+	switch {
+	case err != nil:
+		// Autotune failed, so the run is skipped.
+	case matrix != nil:
+		err = RunMatrix(cfg, matrix)
+	case *scaling:
+		err = Scaling(cfg)
+	case *compare:
+		err = Compare(cfg)
+	case *compareBuild:
+		err = CompareBuilds(cfg)
+	case *comparePayload:
+		err = ComparePayloads(cfg)
+	case len(cpus) > 0:
+		err = CompareCPUs(cfg)
+	case len(depthSweep) > 0:
+		err = Sweep(cfg, "depth", depthSweepPoints())
+	case len(minAllocSweep) > 0:
+		var points []sweepPoint
+		points, err = minAllocSweepPoints(cfg)
+		if err == nil {
+			err = Sweep(cfg, "minalloc", points)
+		}
+	case *repeat > 1:
+		err = Repeat(cfg)
+	case *count > 1:
+		err = Count(cfg)
+	default:
+		start := time.Now()
+		var res *bintree.Results
+		res, err = run(cfg)
+		if *quiet && res != nil {
+			printSummary(res.All(), res.GC, time.Since(start))
+		}
+		if baseline != nil && res != nil && (err == nil || errors.Is(err, bintree.ErrCount)) {
+			// Only the text format has room for the comparison.
+			w := out
+			if cfg.Format != "text" {
+				w = os.Stderr
+			}
+			if cmpErr := CompareBaseline(w, *baselineFile, "this run", baseline, res); cmpErr != nil {
+				slog.Error(cmpErr.Error())
+				failed = true
 			}
+		}
+	}
+	switch {
+	case errors.Is(err, bintree.ErrTimeout):
+		slog.Warn("results are partial", "reason", fmt.Sprint("timed out after ", *timeout))
+		return fmt.Errorf("%w: %w", errFailed, err)
+	case errors.Is(err, bintree.ErrCanceled):
+		slog.Warn("results are partial", "reason", stop.reason)
+		return fmt.Errorf("%w: %w", errFailed, err)
+	case errors.Is(err, bintree.ErrCount):
+		slog.Error(err.Error())
+		return fmt.Errorf("%w: %w", errFailed, err)
+	case err != nil:
+		return err
+	case failed:
+		return errFailed
+	}
+	return nil
+}
 
-			msg := fmt.Sprintf(" %8d trees of depth %-8d arenas: %-6d nodes: %-10d MB: %0.1f",
-				iterations,
-				depth,
-				arenaCount,
-				nodes,
-				float64(nodes*16)/(1<<20))
-			outBuff[index] = msg
+// setFlags returns the flags set on the command line as -name=value.
+func setFlags() []string {
+	var flags []string
+	flag.Visit(func(f *flag.Flag) {
+		flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	return flags
+}
+
+// run runs the benchmark once, like bintree.Run, rendering the results to
+// out unless cfg.Quiet is set, and also writes them to the -out file.
+func run(cfg bintree.Config) (*bintree.Results, error) {
+	res, err := bintree.RunResults(cfg)
+	if res == nil || err != nil && !errors.Is(err, bintree.ErrCanceled) && !errors.Is(err, bintree.ErrCount) {
+		return res, err
+	}
+	if resultsOut != nil {
+		enc := json.NewEncoder(resultsOut)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return res, fmt.Errorf("could not write results file: %w", err)
+		}
+	}
+	if !cfg.Quiet {
+		if err := res.Render(out, cfg.Format); err != nil {
+			return res, err
+		}
+	}
+	return res, err
+}
 
-			treeArena.Free()
-			wg.Done()
-		}(depth, iterations, outCurr)
+// overMaxMem describes the estimate est of the run cfg being over -maxmem,
+// with the largest depth that would fit.
+func overMaxMem(cfg bintree.Config, est bintree.MemoryEstimate) string {
+	msg := fmt.Sprintf("%s is over -maxmem=%0.1f MB, mostly for the %s", est, float64(maxMem)/(1<<20), est.Largest())
+	if fit := cfg.FitDepth(int64(maxMem)); fit > 0 {
+		return msg + fmt.Sprintf("; depth %d would fit", fit)
 	}
+	return msg + "; no depth would fit, lower -minalloc or -workers"
+}
 
-	wg.Wait()
+// isFlagSet reports whether the flag name was set on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	// Compute the checksum of the long-lived binary tree that we created
-	// earlier and store its statistics.
-	nodes := longLivedTree.Count()
-	msg := fmt.Sprintf("long lived tree of depth %-8d arenas: %-6d nodes: %-10d MB: %0.1f",
-		maxDepth,
-		1,
-		nodes,
-		float64(nodes*16)/(1<<20))
-	outBuff[outSize-1] = msg
+// printSummary writes the -quiet one-line summary of a run to out, as
+// space-separated key=value pairs for scripts.
+func printSummary(results []bintree.Result, gc bintree.GCStats, elapsed time.Duration) {
+	t := bintree.SumResults(results)
+	fmt.Fprintf(out, "trees=%d nodes=%d arenas=%d peak_rss_bytes=%d gcs=%d elapsed_ms=%.1f\n",
+		t.Trees, t.Nodes, t.Arenas, gc.PeakRSS, gc.NumGC, float64(elapsed)/float64(time.Millisecond))
+}
 
-	// Print the statistics for all of the various tree depths.
-	for _, m := range outBuff {
-		fmt.Println(m)
+// runStopper closes done to stop the run early when the process receives
+// SIGINT or SIGTERM, so that the profiles and partial results are still
+// written. A second signal kills the process. -timeout stops the run
+// through Config.Deadline instead.
+type runStopper struct {
+	done chan struct{}
+	once sync.Once
+
+	// reason describes why done was closed.
+	reason string
+}
+
+// newRunStopper returns a runStopper that stops the run on the first SIGINT
+// or SIGTERM.
+func newRunStopper() *runStopper {
+	s := &runStopper{done: make(chan struct{})}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		signal.Stop(c)
+		s.stop(fmt.Sprint("interrupted by ", sig))
+	}()
+	return s
+}
+
+func (s *runStopper) stop(reason string) {
+	s.once.Do(func() {
+		slog.Info("stopping the run", "reason", reason)
+		s.reason = reason
+		close(s.done)
+	})
+}
+
+// config returns the benchmark configuration for maxDepth from the flags.
+func config(maxDepth int) bintree.Config {
+	cfg := bintree.DefaultConfig()
+	cfg.Flags = setFlags()
+	cfg.Env = envFlags
+	cfg.MaxDepth = maxDepth
+	cfg.MinDepth = *minDepth
+	cfg.MinAllocMB = *minAllocMB
+	cfg.Iterations = *iterations
+	cfg.IterScale = *iterScale
+	cfg.BenchTime = *benchTime
+	cfg.Warmup = *warmup
+	cfg.WarmupTime = *warmupTime
+	cfg.Workers = *workers
+	cfg.Fanout = *fanout
+	cfg.LockThreads = *lockThreads
+	cfg.Alloc = *allocName
+	if cfg.Alloc == "chunked" {
+		cfg.Alloc = "slab"
+	}
+	if *noArena {
+		cfg.Alloc = "heap"
+	}
+	cfg.ArenaPool = *arenaPool
+	cfg.FreeMode = *freeMode
+	cfg.FreeEvery = *freeEvery
+	if *freeCount != 0 {
+		cfg.FreeMode = "interval"
+		cfg.FreeEvery = *freeCount
+	}
+	cfg.ChunkNodes = *chunkNodes
+	cfg.HeapFrac = *heapFrac
+	cfg.RootIndex = *rootIndex
+	cfg.LeakCheck = *leakCheck
+	cfg.Padding = *padding
+	cfg.Payload = *payload
+	cfg.Build = *build
+	cfg.Layout = *layout
+	cfg.Workload = *workload
+	cfg.Seed = *seed
+	cfg.ListLen = *listLen
+	cfg.BSTKeys = *bstKeys
+	cfg.MutateLevel = *mutateLevel
+	cfg.Branch = *branch
+	cfg.SliceSizes = sliceSizes
+	cfg.BallastMB = *ballastMB
+	cfg.BallastType = *ballastType
+	cfg.MemLimit = int64(memLimit)
+	cfg.CloneLongLived = *cloneLongLived
+	cfg.Compact = *compact
+	cfg.NoValidate = *noValidate
+	cfg.Single = *single || *singleDepth > 0 || *singleIters > 1
+	cfg.SingleDepth = *singleDepth
+	cfg.SingleIters = *singleIters
+	cfg.SerialPhases = *serialPhases
+	cfg.Format = *format
+	if *compat {
+		cfg.Format = "compat"
 	}
+	cfg.Quiet = *quiet
+	if *verbose {
+		cfg.ArenaLog = slog.Default()
+	}
+	return cfg
 }
 
-func main() {
-	flag.Parse()
+// sizeList is a flag.Value holding a comma-separated list of sizes.
+type sizeList []int
+
+func (l *sizeList) String() string {
+	s := make([]string, len(*l))
+	for i, size := range *l {
+		s[i] = strconv.Itoa(size)
+	}
+	return strings.Join(s, ",")
+}
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
+func (l *sizeList) Set(value string) error {
+	var sizes sizeList
+	for _, s := range strings.Split(value, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(s))
 		if err != nil {
-			log.Fatal("could not create CPU profile: ", err)
+			return err
 		}
-		defer f.Close()
-		if err := pprof.StartCPUProfile(f); err != nil {
-			log.Fatal("could not start CPU profile: ", err)
-		}
-		defer pprof.StopCPUProfile()
+		sizes = append(sizes, size)
 	}
+	*l = sizes
+	return nil
+}
 
-	if *memprofile != "" {
-		defer func() {
-			f, err := os.Create(*memprofile)
-			if err != nil {
-				log.Fatal("could not create memory profile: ", err)
-			}
-			defer f.Close()
-			runtime.GC() // get up-to-date statistics
-			if err := pprof.WriteHeapProfile(f); err != nil {
-				log.Fatal("could not write memory profile: ", err)
-			}
-		}()
+// floatList is a flag.Value holding a comma-separated list of numbers.
+type floatList []float64
+
+func (l *floatList) String() string {
+	s := make([]string, len(*l))
+	for i, f := range *l {
+		s[i] = strconv.FormatFloat(f, 'g', -1, 64)
 	}
+	return strings.Join(s, ",")
+}
 
-	n := 21
-	if flag.NArg() > 0 {
-		var err error
-		n, err = strconv.Atoi(flag.Arg(0))
+func (l *floatList) Set(value string) error {
+	var floats floatList
+	for _, s := range strings.Split(value, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
 		if err != nil {
-			log.Fatal("must specify binary tree depth as integer: ", err)
+			return err
 		}
+		floats = append(floats, f)
 	}
+	*l = floats
+	return nil
+}
+
+// byteSize is a flag.Value holding a size in bytes with an optional B, KiB,
+// MiB, GiB or TiB suffix, as in GOMEMLIMIT.
+type byteSize int64
 
-	Run(n)
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+
+func (b *byteSize) Set(value string) error {
+	shift := 0
+	for i, suffix := range []string{"TiB", "GiB", "MiB", "KiB", "B"} {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSuffix(value, suffix)
+			shift = 10 * (4 - i)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > math.MaxInt64>>shift {
+		return fmt.Errorf("size %s out of range", value)
+	}
+	*b = byteSize(n << shift)
+	return nil
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var configFile = flag.String("config", "", "run the matrix of runs described by the JSON `file`: every combination "+
+	"of its depths, minalloc values, allocators and workloads, each repeated as often as it says, taking everything "+
+	"else from the other flags, and write all the results to its output file as one JSON document")
+
+// matrixConfig is a -config file. The lists left out take their single
+// value from the flags.
+type matrixConfig struct {
+	Depths     []int     `json:"depths,omitempty"`
+	MinAlloc   []float64 `json:"minalloc,omitempty"`
+	Allocators []string  `json:"allocators,omitempty"`
+	Workloads  []string  `json:"workloads,omitempty"`
+
+	// Repeat is the number of times each combination runs; 0 means once.
+	Repeat int `json:"repeat,omitempty"`
+
+	// Output is the file the results are written to.
+	Output string `json:"output"`
+}
+
+// matrixRun is a run of the matrix, in the -config output file.
+type matrixRun struct {
+	Depth      int              `json:"depth"`
+	MinAllocMB float64          `json:"minalloc_mb"`
+	Alloc      string           `json:"alloc"`
+	Workload   string           `json:"workload"`
+	Repeat     int              `json:"repeat"`
+	Results    *bintree.Results `json:"results"`
+}
+
+// loadMatrix reads the -config file at path, rejecting unknown fields, and
+// validates it.
+func loadMatrix(path string) (*matrixConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var m matrixConfig
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("could not read -config %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, usageError{fmt.Errorf("invalid -config %s: %w", path, err)}
+	}
+	return &m, nil
+}
+
+// validate returns an error naming the first field of m with an invalid
+// value.
+func (m *matrixConfig) validate() error {
+	for i, d := range m.Depths {
+		if d < 1 || d > *maxDepthAllowed {
+			return fmt.Errorf("depths[%d]: depth %d must be between 1 and %d (see -maxdepth-allowed)", i, d, *maxDepthAllowed)
+		}
+	}
+	for i, mb := range m.MinAlloc {
+		if mb < 0 {
+			return fmt.Errorf("minalloc[%d]: %g must not be negative", i, mb)
+		}
+	}
+	for i, name := range m.Allocators {
+		if !contains(bintree.AllocatorNames(), name) {
+			return fmt.Errorf("allocators[%d]: unknown allocator %q, must be one of %q", i, name, bintree.AllocatorNames())
+		}
+	}
+	for i, name := range m.Workloads {
+		if !contains(bintree.Workloads, name) {
+			return fmt.Errorf("workloads[%d]: unknown workload %q, must be one of %q", i, name, bintree.Workloads)
+		}
+	}
+	switch {
+	case m.Repeat < 0:
+		return fmt.Errorf("repeat: %d must not be negative", m.Repeat)
+	case m.Output == "":
+		return errors.New("output: must name the file to write the results to")
+	}
+	return nil
+}
+
+// runs expands m into the configurations of its runs, in order, with the
+// lists left out taking their value from cfg, and validates each of them.
+func (m *matrixConfig) runs(cfg bintree.Config) ([]bintree.Config, error) {
+	depths, minAllocs, allocs, workloads := m.Depths, m.MinAlloc, m.Allocators, m.Workloads
+	if len(depths) == 0 {
+		depths = []int{cfg.MaxDepth}
+	}
+	if len(minAllocs) == 0 {
+		minAllocs = []float64{cfg.MinAllocMB}
+	}
+	if len(allocs) == 0 {
+		allocs = []string{cfg.Alloc}
+	}
+	if len(workloads) == 0 {
+		workloads = []string{cfg.Workload}
+	}
+	var runs []bintree.Config
+	for _, depth := range depths {
+		for _, mb := range minAllocs {
+			for _, alloc := range allocs {
+				for _, workload := range workloads {
+					cfg.MaxDepth, cfg.MinAllocMB, cfg.Alloc, cfg.Workload = depth, mb, alloc, workload
+					cfg.Label = strings.Join([]string{
+						"depth=" + strconv.Itoa(depth),
+						"minalloc=" + strconv.FormatFloat(mb, 'g', -1, 64),
+						"alloc=" + alloc,
+						"workload=" + workload,
+					}, ",")
+					if err := cfg.Validate(); err != nil {
+						return nil, fmt.Errorf("run %s: %w", cfg.Label, err)
+					}
+					runs = append(runs, cfg)
+				}
+			}
+		}
+	}
+	return runs, nil
+}
+
+// RunMatrix runs every run of m with the flags of cfg in turn, resetting GC
+// state between them, and writes their results to m.Output as one JSON
+// document, which also records m. A canceled run ends the matrix, and the
+// runs done so far are written. As for Sweep, -format=json also prints that
+// document, csv the rows of the runs under one header, and bench only the
+// runs' own output. It returns the first error from bintree.Run, after
+// running the rest of the matrix if that was a wrong node count.
+func RunMatrix(cfg bintree.Config, m *matrixConfig) error {
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	runs, err := m.runs(cfg)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(m.Output)
+	if err != nil {
+		return fmt.Errorf("could not create -config output: %w", err)
+	}
+	defer f.Close()
+
+	repeat := m.Repeat
+	if repeat < 1 {
+		repeat = 1
+	}
+	doc := struct {
+		Config matrixConfig `json:"config"`
+		Runs   []matrixRun  `json:"runs"`
+	}{Config: *m, Runs: []matrixRun{}}
+	var runErr error
+	header := true
+matrix:
+	for _, rc := range runs {
+		label := rc.Label
+		for i := 1; i <= repeat; i++ {
+			if repeat > 1 {
+				rc.Label = fmt.Sprintf("%s,run=%d", label, i)
+			}
+			settleGC()
+			start := time.Now()
+			res, err := run(rc)
+			if *quiet && res != nil {
+				printSummary(res.All(), res.GC, time.Since(start))
+			}
+			if csvOut && res != nil {
+				if err := res.RenderCSV(out, header); err != nil {
+					return err
+				}
+				header = false
+			}
+			if res != nil && (err == nil || errors.Is(err, bintree.ErrCanceled) || errors.Is(err, bintree.ErrCount)) {
+				doc.Runs = append(doc.Runs, matrixRun{Depth: rc.MaxDepth, MinAllocMB: rc.MinAllocMB, Alloc: rc.Alloc,
+					Workload: rc.Workload, Repeat: i, Results: res})
+			}
+			if err != nil && runErr == nil {
+				runErr = err
+			}
+			if err != nil && !errors.Is(err, bintree.ErrCount) {
+				break matrix
+			}
+		}
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("could not write -config output: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write -config output: %w", err)
+	}
+	if jsonOut {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return runErr
+}
+
+// contains reports whether names contains name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+func TestMatrixRuns(t *testing.T) {
+	m := matrixConfig{Depths: []int{6, 8}, Allocators: []string{"arena", "heap"}, Workloads: []string{"tree", "list"},
+		Output: "out.json"}
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := bintree.DefaultConfig()
+	cfg.MinAllocMB = 2
+	runs, err := m.runs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 8 {
+		t.Fatalf("got %d runs, want 8", len(runs))
+	}
+	// The last list varies fastest, and the lists left out come from cfg.
+	if r := runs[1]; r.MaxDepth != 6 || r.Alloc != "arena" || r.Workload != "list" || r.MinAllocMB != 2 {
+		t.Errorf("second run %s, want depth 6 arena list with minalloc 2", r.Label)
+	}
+	if r := runs[7]; r.Label != "depth=8,minalloc=2,alloc=heap,workload=list" {
+		t.Errorf("last run labeled %q", r.Label)
+	}
+
+	for _, bad := range []struct {
+		m     matrixConfig
+		field string
+	}{
+		{matrixConfig{Depths: []int{6, 0}, Output: "out.json"}, "depths[1]"},
+		{matrixConfig{MinAlloc: []float64{-1}, Output: "out.json"}, "minalloc[0]"},
+		{matrixConfig{Allocators: []string{"arena", "stack"}, Output: "out.json"}, "allocators[1]"},
+		{matrixConfig{Workloads: []string{"trees"}, Output: "out.json"}, "workloads[0]"},
+		{matrixConfig{Repeat: -1, Output: "out.json"}, "repeat"},
+		{matrixConfig{}, "output"},
+	} {
+		if err := bad.m.validate(); err == nil || !strings.HasPrefix(err.Error(), bad.field+":") {
+			t.Errorf("%+v: got error %v, want one naming %s", bad.m, err, bad.field)
+		}
+	}
+}
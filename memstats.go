@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+var (
+	memStatsInterval = flag.Duration("memstatsinterval", 0, "sample MemStats every `interval` into -memstatsfile")
+	memStatsFile     = flag.String("memstatsfile", "", "write the MemStats time series as CSV to `file`")
+)
+
+// startMemStatsSampler samples runtime.ReadMemStats every -memstatsinterval,
+// writing a CSV row per sample to -memstatsfile. The returned stop function
+// waits for the final row to be written and closes the file. It is a no-op
+// if -memstatsinterval is not set.
+func startMemStatsSampler() (stop func() error, err error) {
+	if *memStatsInterval <= 0 {
+		return func() error { return nil }, nil
+	}
+	if *memStatsFile == "" {
+		return nil, usageError{fmt.Errorf("-memstatsinterval requires -memstatsfile")}
+	}
+	f, err := os.Create(*memStatsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "time,elapsed_ms,heap_alloc,heap_inuse,heap_idle,num_gc")
+
+	start := time.Now()
+	sample := func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		now := time.Now()
+		fmt.Fprintf(w, "%s,%.3f,%d,%d,%d,%d\n",
+			now.Format(time.RFC3339Nano),
+			float64(now.Sub(start))/float64(time.Millisecond),
+			ms.HeapAlloc,
+			ms.HeapInuse,
+			ms.HeapIdle,
+			ms.NumGC)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(*memStatsInterval)
+		defer ticker.Stop()
+		sample()
+		for {
+			select {
+			case <-ticker.C:
+				sample()
+			case <-done:
+				sample()
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-stopped
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}, nil
+}
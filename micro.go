@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var (
+	micro = flag.String("micro", "", "instead of benchmarking trees, run the `microbenchmark` "+
+		strings.Join(bintree.Micros, ", ")+"; newarena times creating and freeing arenas, alloc times "+
+		"allocating single nodes of the -payload with the -alloc allocator, or every allocator with -alloc=all, "+
+		"recycling it every -minalloc")
+	microOps = flag.Int("microops", 10000, "number of `operations` of -micro")
+)
+
+// Micro runs the -micro microbenchmark and prints its results.
+func Micro() error {
+	if *microOps < 1 {
+		return usageError{fmt.Errorf("-microops must be at least 1, not %d", *microOps)}
+	}
+	if *allocName == "all" && *micro != "alloc" {
+		return usageError{fmt.Errorf("-alloc=all needs -micro=alloc")}
+	}
+	var bench func() []bintree.MicroResult
+	ignored := "the tree workload and its flags"
+	switch *micro {
+	case "newarena":
+		bench = func() []bintree.MicroResult { return bintree.MicroNewArena(*microOps) }
+	case "alloc":
+		ignored = "the tree workload"
+		cfg := config(*depth)
+		names := []string{cfg.Alloc}
+		if *allocName == "all" {
+			names = bintree.AllocatorNames()
+		}
+		for _, name := range names {
+			cfg.Alloc = name
+			if err := cfg.Validate(); err != nil {
+				return usageError{fmt.Errorf("invalid flags: %w", err)}
+			}
+		}
+		bench = func() []bintree.MicroResult {
+			results := make([]bintree.MicroResult, 0, len(names))
+			for _, name := range names {
+				cfg.Alloc = name
+				settleGC()
+				results = append(results, bintree.MicroAlloc(cfg, *microOps))
+			}
+			return results
+		}
+	default:
+		return usageError{fmt.Errorf("unknown microbenchmark %q, must be one of %q", *micro, bintree.Micros)}
+	}
+	slog.Warn(fmt.Sprintf("-micro=%s ignores %s", *micro, ignored))
+	bintree.PrintMicro(out, bench())
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+	"time"
+)
+
+var (
+	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to `file`")
+	memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+
+	memprofilerate = flag.Int("memprofilerate", runtime.MemProfileRate, "record one heap allocation sample per `bytes` allocated "+
+		"(1 records every allocation, which slows the heap allocator down considerably)")
+
+	memProfileInterval = flag.Duration("memprofileinterval", 0, "write a heap profile every `interval` during the run "+
+		"into -memprofiledir (at least 100ms)")
+	memProfileDir = flag.String("memprofiledir", "", "write the -memprofileinterval heap profiles to `dir`")
+
+	blockprofile     = flag.String("blockprofile", "", "write goroutine blocking profile to `file`")
+	blockprofilerate = flag.Int("blockprofilerate", 1, "with -blockprofile, sample one blocking event per `rate` nanoseconds "+
+		"spent blocked (see runtime.SetBlockProfileRate)")
+
+	goroutineprofile = flag.String("goroutineprofile", "", "write goroutine profile to `file` at exit, "+
+		"and whenever the process receives SIGQUIT")
+
+	mutexprofile         = flag.String("mutexprofile", "", "write mutex contention profile to `file`")
+	mutexprofilefraction = flag.Int("mutexprofilefraction", 1, "with -mutexprofile, sample 1 in `n` mutex contention events "+
+		"(see runtime.SetMutexProfileFraction)")
+)
+
+// exitProfile is a named runtime/pprof profile written to a file at exit.
+type exitProfile struct {
+	name, file string
+
+	// before, if not nil, runs just before the profile is written.
+	before func()
+}
+
+// startProfiles starts the profiles requested by the flags. The returned
+// stop function stops CPU profiling, writes the other profiles, and restores
+// the profiling rates so later runs in the process are not skewed. It
+// returns the first error writing a profile, after trying all of them.
+func startProfiles() (stop func() error, err error) {
+	var cpuFile *os.File
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			return nil, fmt.Errorf("could not create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not start CPU profile: %w", err)
+		}
+		slog.Debug("writing CPU profile", "file", *cpuprofile)
+		cpuFile = f
+	}
+	if *blockprofile != "" {
+		runtime.SetBlockProfileRate(*blockprofilerate)
+	}
+	if *mutexprofile != "" {
+		runtime.SetMutexProfileFraction(*mutexprofilefraction)
+	}
+
+	if *goroutineprofile != "" {
+		// Replace the runtime's dump-and-exit on SIGQUIT, so a hung run can
+		// be inspected and left running.
+		onSignal(syscall.SIGQUIT, func() {
+			slog.Info("received SIGQUIT, writing goroutine profile", "file", *goroutineprofile)
+			if err := writeProfile("goroutine", *goroutineprofile); err != nil {
+				slog.Error(err.Error())
+			}
+		})
+	}
+
+	// The goroutine profile comes first, to capture the goroutines as they
+	// were when main returned or panicked rather than after a GC.
+	profiles := []exitProfile{
+		{name: "goroutine", file: *goroutineprofile},
+		{name: "heap", file: *memprofile, before: runtime.GC}, // get up-to-date statistics
+		{name: "block", file: *blockprofile},
+		{name: "mutex", file: *mutexprofile},
+	}
+	return func() error {
+		var err error
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			err = cpuFile.Close()
+		}
+		for _, p := range profiles {
+			if p.file == "" {
+				continue
+			}
+			if p.before != nil {
+				p.before()
+			}
+			if writeErr := writeProfile(p.name, p.file); err == nil {
+				err = writeErr
+			}
+		}
+		runtime.SetBlockProfileRate(0)
+		runtime.SetMutexProfileFraction(0)
+		return err
+	}, nil
+}
+
+// minMemProfileInterval is the shortest -memprofileinterval: each snapshot
+// runs a full GC, so shorter intervals would mostly measure the snapshots.
+const minMemProfileInterval = 100 * time.Millisecond
+
+// startHeapSnapshots writes a heap profile, preceded by a GC for accurate
+// live object counts, every -memprofileinterval to numbered files in
+// -memprofiledir. The returned stop function waits for any snapshot in
+// progress to be written and reports the first error. It is a no-op if
+// -memprofileinterval is not set.
+func startHeapSnapshots() (stop func() error, err error) {
+	if *memProfileInterval <= 0 {
+		return func() error { return nil }, nil
+	}
+	if *memProfileInterval < minMemProfileInterval {
+		return nil, usageError{fmt.Errorf("-memprofileinterval %v is shorter than %v", *memProfileInterval, minMemProfileInterval)}
+	}
+	if *memProfileDir == "" {
+		return nil, usageError{fmt.Errorf("-memprofileinterval requires -memprofiledir")}
+	}
+	if err := os.MkdirAll(*memProfileDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	snapshot := func(n int) error {
+		f, err := os.Create(filepath.Join(*memProfileDir, fmt.Sprintf("profile.%04d.pb.gz", n)))
+		if err != nil {
+			return err
+		}
+		runtime.GC()
+		if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+			f.Close()
+			return err
+		}
+		slog.Debug("wrote heap profile snapshot", "file", f.Name())
+		return f.Close()
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var snapshotErr error
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(*memProfileInterval)
+		defer ticker.Stop()
+		for n := 1; ; n++ {
+			select {
+			case <-ticker.C:
+				if snapshotErr = snapshot(n); snapshotErr != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-stopped
+		return snapshotErr
+	}, nil
+}
+
+// writeProfile writes the named runtime/pprof profile to file.
+func writeProfile(name, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("could not create %s profile: %w", name, err)
+	}
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write %s profile: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write %s profile: %w", name, err)
+	}
+	slog.Debug("wrote profile", "profile", name, "file", file)
+	return nil
+}
+
+// onSignal calls f, in its own goroutine, every time the process receives sig.
+func onSignal(sig os.Signal, f func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	go func() {
+		for range c {
+			f()
+		}
+	}()
+}
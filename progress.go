@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var showProgress = flag.Bool("progress", false, "log the per-depth progress of the run, the nodes built so far "+
+	"and HeapInuse every few seconds")
+
+// progressInterval is how often -progress logs a status line.
+const progressInterval = 5 * time.Second
+
+// startProgressReporter logs a status line of the run's progress every
+// progressInterval while a run is in flight. The run holds off
+// writing its results while a line is logged, so they do not interleave.
+// The returned stop function waits for the reporter to exit. It is a no-op
+// if -progress is not set.
+func startProgressReporter() (stop func()) {
+	if !*showProgress {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				var ms runtime.MemStats
+				runtime.ReadMemStats(&ms)
+				progress.WhileRunning(func(s bintree.ProgressStatus) {
+					slog.Info(progressLine(s, ms.HeapInuse))
+				})
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// progressLine formats a -progress status line: the percentage of the trees
+// of each depth completed, or their number with -benchtime, the nodes built
+// so far and heapInuse.
+func progressLine(s bintree.ProgressStatus, heapInuse uint64) string {
+	var b strings.Builder
+	b.WriteString("progress:")
+	if s.Pass != "" {
+		fmt.Fprintf(&b, " %s", s.Pass)
+	}
+	for _, d := range s.Depths {
+		if d.Iterations > 0 {
+			fmt.Fprintf(&b, " depth %d %.0f%%", d.Depth, 100*float64(d.Completed)/float64(d.Iterations))
+		} else {
+			fmt.Fprintf(&b, " depth %d %d trees", d.Depth, d.Completed)
+		}
+	}
+	fmt.Fprintf(&b, " nodes: %d HeapInuse MB: %.1f", s.Totals.Nodes, float64(heapInuse)/(1<<20))
+	return b.String()
+}
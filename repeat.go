@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var repeat = flag.Int("repeat", 1, "run the whole benchmark `n` times and report mean, stddev, min and max timings")
+
+// repeatStats is the JSON summary of the timings of a depth, or of the whole
+// run if Depth is not set, across the repetitions.
+type repeatStats struct {
+	Depth  int           `json:"depth,omitempty"`
+	Mean   time.Duration `json:"mean_ns"`
+	Stddev time.Duration `json:"stddev_ns"`
+	Min    time.Duration `json:"min_ns"`
+	Max    time.Duration `json:"max_ns"`
+}
+
+func newRepeatStats(depth int, xs []float64) repeatStats {
+	s := summarize(xs)
+	return repeatStats{
+		Depth:  depth,
+		Mean:   time.Duration(s.mean),
+		Stddev: time.Duration(s.stddev),
+		Min:    time.Duration(s.min),
+		Max:    time.Duration(s.max),
+	}
+}
+
+// Repeat runs the benchmark -repeat times, with a GC between repetitions so
+// they are independent, and prints timing statistics across the repetitions.
+// As for Sweep, -format=json prints a single document of the statistics
+// instead of the repetitions, csv the rows of the repetitions under one
+// header, and bench only the repetitions' own output. It returns the first
+// error from bintree.Run.
+func Repeat(cfg bintree.Config) error {
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	var (
+		totals   []float64
+		depths   []int
+		perDepth = make(map[int][]float64)
+	)
+	for i := 0; i < *repeat; i++ {
+		settleGC()
+		res, err := run(cfg)
+		if csvOut && res != nil {
+			if err := res.RenderCSV(out, i == 0); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		results := res.All()
+		totals = append(totals, float64(res.Totals.Elapsed))
+
+		for _, r := range results {
+			if r.Kind != bintree.KindDepth {
+				continue
+			}
+			if i == 0 {
+				depths = append(depths, r.Depth)
+			}
+			perDepth[r.Depth] = append(perDepth[r.Depth], float64(r.Elapsed))
+		}
+	}
+
+	if jsonOut {
+		doc := struct {
+			Repeat int           `json:"repeat"`
+			Depths []repeatStats `json:"depths"`
+			Total  repeatStats   `json:"total"`
+		}{Repeat: *repeat, Depths: []repeatStats{}, Total: newRepeatStats(0, totals)}
+		for _, depth := range depths {
+			doc.Depths = append(doc.Depths, newRepeatStats(depth, perDepth[depth]))
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	}
+	if cfg.Format != "text" {
+		return nil
+	}
+
+	fmt.Fprintf(out, "\n%d repetitions\n", *repeat)
+	fmt.Fprintf(out, "%-16s %12s %12s %12s %12s\n", "", "mean", "stddev", "min", "max")
+	printStats := func(name string, xs []float64) {
+		s := summarize(xs)
+		fmt.Fprintf(out, "%-16s %12v %12v %12v %12v\n",
+			name,
+			roundDuration(s.mean),
+			roundDuration(s.stddev),
+			roundDuration(s.min),
+			roundDuration(s.max))
+	}
+	for _, depth := range depths {
+		printStats(fmt.Sprintf("depth %d", depth), perDepth[depth])
+	}
+	printStats("total", totals)
+	return nil
+}
+
+// stats summarizes a sample.
+type stats struct {
+	mean, stddev, min, max float64
+}
+
+// summarize returns the mean, sample standard deviation, min and max of xs.
+func summarize(xs []float64) stats {
+	if len(xs) == 0 {
+		return stats{}
+	}
+	s := stats{min: xs[0], max: xs[0]}
+	for _, x := range xs {
+		s.mean += x
+		s.min = math.Min(s.min, x)
+		s.max = math.Max(s.max, x)
+	}
+	s.mean /= float64(len(xs))
+	if len(xs) > 1 {
+		var ss float64
+		for _, x := range xs {
+			ss += (x - s.mean) * (x - s.mean)
+		}
+		s.stddev = math.Sqrt(ss / float64(len(xs)-1))
+	}
+	return s
+}
+
+// roundDuration converts ns to a Duration rounded for display.
+func roundDuration(ns float64) time.Duration {
+	return time.Duration(ns).Round(10 * time.Microsecond)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var (
+	scaling = flag.Bool("scaling", false, "build the same number of trees of one depth with 1, 2, 4 and so on up to "+
+		"NumCPU concurrent workers, each with its own arena, printing trees/sec and the speedup of each width; "+
+		"with -compare, each width is also run with the heap allocator")
+	scalingDepth = flag.Int("scalingdepth", 16, "`depth` of the trees built by -scaling")
+	scalingTrees = flag.Int("scalingtrees", 256, "number of `trees` built at every width of -scaling")
+)
+
+// scalingConfig returns cfg set up for a pass of -scaling: single mode
+// building -scalingtrees trees of -scalingdepth, split across the workers by
+// Fanout.
+func scalingConfig(cfg bintree.Config) bintree.Config {
+	cfg.Single = true
+	cfg.SingleDepth = *scalingDepth
+	cfg.SingleIters = *scalingTrees
+	cfg.MaxDepth = *scalingDepth
+	return cfg
+}
+
+// scalingWidths returns the worker counts of -scaling: the powers of two
+// below NumCPU, and NumCPU.
+func scalingWidths() []int {
+	var widths []int
+	for n := 1; n < runtime.NumCPU(); n *= 2 {
+		widths = append(widths, n)
+	}
+	return append(widths, runtime.NumCPU())
+}
+
+// Scaling runs the -scaling passes, resetting GC state between them, and
+// prints the tree rate of each width and its speedup over a single worker.
+// With -compare every width is run with the arena and then the heap
+// allocator, and the table has a column pair for each. As for Sweep,
+// -format=json prints a single document nesting the passes, and bench gets
+// only the passes' own output; csv prints the rows of the table, one per
+// pass, under one header. It returns the first error from bintree.Run.
+func Scaling(cfg bintree.Config) error {
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	allocs := []string{cfg.Alloc}
+	if *compare {
+		allocs = []string{"arena", "heap"}
+	}
+	widths := scalingWidths()
+	// passes[i][j] is the pass of widths[i] with allocs[j].
+	passes := make([][]passResult, len(widths))
+	for i, n := range widths {
+		passes[i] = make([]passResult, len(allocs))
+		for j, alloc := range allocs {
+			cfg.Alloc = alloc
+			cfg.Fanout = n
+			cfg.Label = fmt.Sprintf("workers=%d", n)
+			if len(allocs) > 1 {
+				cfg.Label += "," + alloc
+			}
+			settleGC()
+			p, err := runPass(cfg)
+			if err != nil {
+				return err
+			}
+			passes[i][j] = p
+		}
+	}
+	if jsonOut {
+		var all []passResult
+		for _, ps := range passes {
+			all = append(all, ps...)
+		}
+		return writePasses("scaling", all)
+	}
+	if csvOut {
+		return scalingCSV(widths, allocs, passes)
+	}
+	if cfg.Format != "text" {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-8s", "workers")
+	for _, alloc := range allocs {
+		fmt.Fprintf(out, " %16s %9s", alloc+" trees/sec", "speedup")
+	}
+	fmt.Fprintf(out, " %12s\n", "wall")
+	for i, n := range widths {
+		fmt.Fprintf(out, "%-8d", n)
+		var wall time.Duration
+		for j := range allocs {
+			rate := scalingRate(passes[i][j])
+			fmt.Fprintf(out, " %16.0f %8.2fx", rate, scalingSpeedup(passes, i, j))
+			wall += passes[i][j].elapsed
+		}
+		fmt.Fprintf(out, " %12v\n", wall.Round(time.Millisecond))
+	}
+	fmt.Fprintf(out, "(%d trees of depth %d per pass; speedup is the tree rate relative to 1 worker)\n",
+		*scalingTrees, *scalingDepth)
+	return nil
+}
+
+// scalingRate returns the rate at which a -scaling pass built its trees,
+// leaving out the setup and teardown of the run.
+func scalingRate(p passResult) float64 {
+	if len(p.results) == 0 || p.results[0].Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.results[0].Iterations) / p.results[0].Elapsed.Seconds()
+}
+
+// scalingSpeedup returns the tree rate of passes[i][j] relative to that of
+// the single worker pass with the same allocator, or 0 if it has none.
+func scalingSpeedup(passes [][]passResult, i, j int) float64 {
+	base := scalingRate(passes[0][j])
+	if base <= 0 {
+		return 0
+	}
+	return scalingRate(passes[i][j]) / base
+}
+
+// scalingCSV writes the -scaling table to out in the csv format, one row per
+// pass, with the worker count and allocator as columns.
+func scalingCSV(widths []int, allocs []string, passes [][]passResult) error {
+	cw := csv.NewWriter(out)
+	cw.Write([]string{"pass", "workers", "alloc", "trees", "depth", "ms", "trees_per_sec", "speedup"})
+	for i, n := range widths {
+		for j, alloc := range allocs {
+			p := passes[i][j]
+			var trees int
+			if len(p.results) > 0 {
+				trees = p.results[0].Iterations
+			}
+			cw.Write([]string{
+				p.name,
+				strconv.Itoa(n),
+				alloc,
+				strconv.Itoa(trees),
+				strconv.Itoa(*scalingDepth),
+				strconv.FormatFloat(float64(p.elapsed)/float64(time.Millisecond), 'f', 3, 64),
+				strconv.FormatFloat(scalingRate(p), 'f', 0, 64),
+				strconv.FormatFloat(scalingSpeedup(passes, i, j), 'f', 2, 64),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var (
+	depthSweep    sizeList
+	minAllocSweep floatList
+)
+
+func init() {
+	flag.Var(&depthSweep, "depthsweep", "comma-separated maximum `depths` such as 14,16,18,20,21 to run the benchmark "+
+		"with in turn, printing a comparison")
+	flag.Var(&minAllocSweep, "minallocsweep", "comma-separated -minalloc `values` such as 0.25,0.5,1,4,16,64 to run the "+
+		"benchmark with in turn, printing a comparison")
+}
+
+// sweepPoint is one value of a swept parameter.
+type sweepPoint struct {
+	label string
+	apply func(cfg *bintree.Config)
+}
+
+// sweepPass is the JSON summary of one pass of a sweep.
+type sweepPass struct {
+	Value   string          `json:"value"`
+	Elapsed time.Duration   `json:"elapsed_ns"`
+	Totals  bintree.Totals  `json:"totals"`
+	PeakRSS uint64          `json:"peak_rss_bytes,omitempty"`
+	GC      bintree.GCStats `json:"gc"`
+}
+
+// Sweep runs the benchmark once for each point, resetting GC state between
+// the passes so that each starts with every arena of the previous one freed
+// and collected, and prints a comparison row per point. With -format=json
+// the passes are not printed on their own, and the sweep is a single JSON
+// document nesting an array of them. With csv the rows of the passes share
+// one header. It returns the first error from bintree.Run.
+func Sweep(cfg bintree.Config, name string, points []sweepPoint) error {
+	jsonOut := cfg.Format == "json"
+	csvOut := cfg.Format == "csv" && !cfg.Quiet
+	if jsonOut || csvOut {
+		cfg.Quiet = true
+	}
+	passes := make([]passResult, len(points))
+	for i, pt := range points {
+		pt.apply(&cfg)
+		cfg.Label = pt.label
+		settleGC()
+		p, err := runPass(cfg)
+		if csvOut {
+			if err := p.renderCSV(i); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		passes[i] = p
+	}
+
+	if jsonOut {
+		doc := struct {
+			Sweep  string      `json:"sweep"`
+			Passes []sweepPass `json:"passes"`
+		}{Sweep: name}
+		for _, p := range passes {
+			doc.Passes = append(doc.Passes, p.summary())
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	}
+	if cfg.Format != "text" {
+		// The passes' rows are all the csv and bench formats get.
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-10s %12s %14s %12s %8s %12s\n", name, "wall", "nodes/sec", "nodes", "arenas", "peak RSS MB")
+	for i, p := range passes {
+		t := bintree.SumResults(p.results)
+		fmt.Fprintf(out, "%-10s %12v %14.0f %12d %8d %12.1f\n",
+			points[i].label,
+			p.elapsed.Round(time.Millisecond),
+			p.nodesPerSec(),
+			t.Nodes,
+			t.Arenas,
+			float64(p.gc.PeakRSS)/(1<<20))
+	}
+	return nil
+}
+
+// depthSweepPoints returns the -depthsweep points.
+func depthSweepPoints() []sweepPoint {
+	points := make([]sweepPoint, len(depthSweep))
+	for i, depth := range depthSweep {
+		depth := depth
+		points[i] = sweepPoint{
+			label: fmt.Sprintf("depth=%d", depth),
+			apply: func(cfg *bintree.Config) { cfg.MaxDepth = depth },
+		}
+	}
+	return points
+}
+
+// minAllocSweepPoints returns the -minallocsweep points. The number of trees
+// per depth does not depend on minalloc, so every point builds the same
+// trees, unless -benchtime decides how many.
+func minAllocSweepPoints(cfg bintree.Config) ([]sweepPoint, error) {
+	if cfg.BenchTime > 0 {
+		return nil, usageError{errors.New("-minallocsweep cannot be combined with -benchtime, which would build different trees at each point")}
+	}
+	points := make([]sweepPoint, len(minAllocSweep))
+	for i, mb := range minAllocSweep {
+		if mb < 0 {
+			return nil, usageError{fmt.Errorf("-minallocsweep values must not be negative, not %g", mb)}
+		}
+		mb := mb
+		points[i] = sweepPoint{
+			label: fmt.Sprintf("%gMB", mb),
+			apply: func(cfg *bintree.Config) { cfg.MinAllocMB = mb },
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/vmihailenco/golang-memory-arena/bintree"
+)
+
+var demonstrateUAF = flag.Bool("demonstrate-uaf", false, "DANGEROUS: instead of benchmarking, deliberately use a tree "+
+	"after freeing its arena and report whether the runtime faulted as it should")
+
+// uafDepth is the depth of the tree used by DemonstrateUAF. Freeing an
+// arena only makes its full 8 MB chunks fault; the chunk it was allocating
+// from is kept for reuse by the next arena. A 32 MB tree spans several
+// chunks, and since its nodes are allocated children first, most of them,
+// starting with the root's left subtree, are in full chunks.
+const uafDepth = 20
+
+// DemonstrateUAF builds a tree in an arena, frees the arena and then counts
+// the tree through the stale pointer, to check that the toolchain detects the
+// use after free by faulting rather than reading freed memory. The count
+// runs in its own goroutine with debug.SetPanicOnFault, so that the fault is
+// recovered as a panic instead of crashing the process. It reports whether
+// the runtime faulted.
+func DemonstrateUAF() bool {
+	fmt.Printf("demonstrate-uaf: building a tree of depth %d in an arena, freeing the arena, "+
+		"then counting the tree through the stale pointer\n", uafDepth)
+	fmt.Println("demonstrate-uaf: the runtime is expected to fault; the fault is recovered in an isolated goroutine")
+
+	a := bintree.NewArenaAllocator[struct{}]()
+	tree := bintree.NewTree[struct{}](uafDepth, a)
+	a.Free()
+
+	type outcome struct {
+		nodes int
+		fault any
+	}
+	done := make(chan outcome)
+	go func() {
+		debug.SetPanicOnFault(true)
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{fault: r}
+			}
+		}()
+		done <- outcome{nodes: tree.Count()}
+	}()
+	res := <-done
+
+	if res.fault == nil {
+		fmt.Printf("demonstrate-uaf: NO FAULT: counted %d nodes in freed memory\n", res.nodes)
+		return false
+	}
+	fmt.Printf("demonstrate-uaf: faulted as expected: %v\n", res.fault)
+	return true
+}